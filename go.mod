@@ -4,12 +4,11 @@ go 1.20
 
 require (
 	github.com/bazelbuild/bazel-gazelle v0.20.0
-	github.com/go-python/gpython v0.1.0
+	github.com/bazelbuild/buildtools v0.0.0-20190731111112-f720930ceb60
 	github.com/google/go-cmp v0.5.9
+	github.com/pelletier/go-toml v1.9.5
 	gopkg.in/yaml.v2 v2.2.2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	github.com/bazelbuild/buildtools v0.0.0-20190731111112-f720930ceb60 // indirect
-	golang.org/x/tools v0.0.0-20190122202912-9c309ee22fab // indirect
-)
+require golang.org/x/tools v0.0.0-20190122202912-9c309ee22fab // indirect
@@ -0,0 +1,77 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package internal
+
+import "sort"
+
+// Cycles returns the strongly connected components of the graph with the
+// nodes and the edges given by deps, using Tarjan's algorithm, i.e. the nodes
+// which depend on each other cyclically. Each component is sorted, and nodes
+// which are not part of a cycle are in a component of their own. Dependencies
+// which are not nodes of the graph are ignored.
+func Cycles(nodes []string, deps func(node string) []string) [][]string {
+	var (
+		isNode  = make(map[string]bool, len(nodes))
+		index   = make(map[string]int)
+		lowLink = make(map[string]int)
+		onStack = make(map[string]bool)
+		stack   []string
+		res     [][]string
+		visit   func(node string)
+	)
+	for _, node := range nodes {
+		isNode[node] = true
+	}
+	visit = func(node string) {
+		index[node] = len(index)
+		lowLink[node] = index[node]
+		stack = append(stack, node)
+		onStack[node] = true
+		for _, dep := range deps(node) {
+			if !isNode[dep] {
+				continue
+			}
+			if _, ok := index[dep]; !ok {
+				visit(dep)
+				if lowLink[dep] < lowLink[node] {
+					lowLink[node] = lowLink[dep]
+				}
+			} else if onStack[dep] && index[dep] < lowLink[node] {
+				lowLink[node] = index[dep]
+			}
+		}
+		if lowLink[node] != index[node] {
+			return
+		}
+		var cycle []string
+		for {
+			member := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[member] = false
+			cycle = append(cycle, member)
+			if member == node {
+				break
+			}
+		}
+		sort.Strings(cycle)
+		res = append(res, cycle)
+	}
+	for _, node := range nodes {
+		if _, ok := index[node]; !ok {
+			visit(node)
+		}
+	}
+	return res
+}
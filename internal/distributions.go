@@ -0,0 +1,59 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DistNameStyle is a style for normalizing the names of distributions.
+type DistNameStyle string
+
+const (
+	// Names as normalized by PEP 503, e.g. "ruamel-yaml" for "ruamel.yaml".
+	DistNameDash DistNameStyle = "dash"
+	// Names as normalized by PEP 503, but with "_" as the separator, as in the
+	// names of the repositories created by pip_parse, e.g. "ruamel_yaml".
+	DistNameUnderscore DistNameStyle = "underscore"
+	// Names in lower case, with the separators unchanged, e.g. "ruamel.yaml".
+	DistNameLowercase DistNameStyle = "lowercase"
+)
+
+// ParseDistNameStyle returns the style with the given name.
+func ParseDistNameStyle(name string) (DistNameStyle, error) {
+	switch style := DistNameStyle(name); style {
+	case DistNameDash, DistNameUnderscore, DistNameLowercase:
+		return style, nil
+	}
+	return "", fmt.Errorf("unknown distribution name style %q; must be one of %q, %q or %q", name, DistNameDash, DistNameUnderscore, DistNameLowercase)
+}
+
+// Runs of separators in distribution names.
+var distNameSeparators = regexp.MustCompile("[-_.]+")
+
+// NormalizeDistName normalizes the name of a distribution in the given style.
+// https://peps.python.org/pep-0503/#normalized-names
+func NormalizeDistName(name string, style DistNameStyle) string {
+	name = strings.ToLower(name)
+	switch style {
+	case DistNameDash:
+		return distNameSeparators.ReplaceAllLiteralString(name, "-")
+	case DistNameUnderscore:
+		return distNameSeparators.ReplaceAllLiteralString(name, "_")
+	}
+	return name
+}
@@ -0,0 +1,52 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package internal
+
+import "testing"
+
+func TestNormalizeDistName(t *testing.T) {
+	testCases := []struct {
+		name  string
+		style DistNameStyle
+		want  string
+	}{
+		{"PyYAML", DistNameDash, "pyyaml"},
+		{"PyYAML", DistNameUnderscore, "pyyaml"},
+		{"ruamel.yaml", DistNameDash, "ruamel-yaml"},
+		{"ruamel.yaml", DistNameUnderscore, "ruamel_yaml"},
+		{"ruamel.yaml", DistNameLowercase, "ruamel.yaml"},
+		{"typing_extensions", DistNameDash, "typing-extensions"},
+		{"Friendly-Bard", DistNameLowercase, "friendly-bard"},
+		{"Friendly__.-Bard", DistNameDash, "friendly-bard"},
+		{"zope.interface", DistNameUnderscore, "zope_interface"},
+	}
+
+	for i, testCase := range testCases {
+		if got, want := NormalizeDistName(testCase.name, testCase.style), testCase.want; got != want {
+			t.Errorf("test %d: %q != %q", i, got, want)
+		}
+	}
+}
+
+func TestParseDistNameStyle(t *testing.T) {
+	for _, name := range []string{"dash", "underscore", "lowercase"} {
+		if _, err := ParseDistNameStyle(name); err != nil {
+			t.Errorf("unexpected error for %q: %v", name, err)
+		}
+	}
+	if _, err := ParseDistNameStyle("camel"); err == nil {
+		t.Errorf("expected error for unknown style")
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package internal
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Lists of top-level standard library modules, one per line, named by the
+// CPython version.
+//
+//go:embed stdlib/*.txt
+var stdlibFiles embed.FS
+
+var (
+	stdlibMu      sync.Mutex
+	stdlibModules = make(map[string]map[string]struct{})
+)
+
+// StdlibVersions returns the Python versions for which the standard library
+// module lists are available, in increasing order.
+func StdlibVersions() []string {
+	entries, err := stdlibFiles.ReadDir("stdlib")
+	if err != nil {
+		panic(err)
+	}
+	var res []string
+	for _, entry := range entries {
+		res = append(res, strings.TrimSuffix(entry.Name(), ".txt"))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		var majorI, minorI, majorJ, minorJ int
+		fmt.Sscanf(res[i], "%d.%d", &majorI, &minorI)
+		fmt.Sscanf(res[j], "%d.%d", &majorJ, &minorJ)
+		if majorI != majorJ {
+			return majorI < majorJ
+		}
+		return minorI < minorJ
+	})
+	return res
+}
+
+// StdlibModules returns the top-level modules in the standard library of the
+// given CPython version, e.g. "3.11". The returned map must not be modified.
+func StdlibModules(version string) (map[string]struct{}, error) {
+	stdlibMu.Lock()
+	defer stdlibMu.Unlock()
+	if modules, ok := stdlibModules[version]; ok {
+		return modules, nil
+	}
+	f, err := stdlibFiles.Open("stdlib/" + version + ".txt")
+	if err != nil {
+		return nil, fmt.Errorf("unsupported Python version %q; must be one of %s", version, strings.Join(StdlibVersions(), ", "))
+	}
+	defer f.Close()
+	modules := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		modules[line] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	stdlibModules[version] = modules
+	return modules, nil
+}
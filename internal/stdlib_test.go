@@ -0,0 +1,61 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package internal
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStdlibVersions(t *testing.T) {
+	want := []string{"3.8", "3.9", "3.10", "3.11", "3.12", "3.13"}
+	if diff := cmp.Diff(StdlibVersions(), want); diff != "" {
+		t.Errorf("(-got, +want):%s", diff)
+	}
+}
+
+func TestStdlibModules(t *testing.T) {
+	testCases := []struct {
+		version string
+		module  string
+		want    bool
+	}{
+		{"3.8", "os", true},
+		{"3.8", "dummy_threading", true},
+		{"3.8", "zoneinfo", false},
+		{"3.9", "zoneinfo", true},
+		{"3.10", "tomllib", false},
+		{"3.11", "tomllib", true},
+		{"3.11", "distutils", true},
+		{"3.12", "distutils", false},
+		{"3.12", "telnetlib", true},
+		{"3.13", "telnetlib", false},
+		{"3.13", "sys", true},
+		{"3.13", "yaml", false},
+	}
+	for i, tc := range testCases {
+		modules, err := StdlibModules(tc.version)
+		if err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		if _, got := modules[tc.module]; got != tc.want {
+			t.Errorf("test %d: module %q in Python %s: got %t, want %t", i, tc.module, tc.version, got, tc.want)
+		}
+	}
+	if _, err := StdlibModules("2.7"); err == nil {
+		t.Errorf("expected error for unsupported version")
+	}
+}
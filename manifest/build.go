@@ -0,0 +1,153 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/siddharthab/bazel-gazelle-python/internal"
+)
+
+// Writes a BUILD file for each distribution, named <dist>.BUILD in the
+// directory, with a py_library for each module in the distribution, e.g. to
+// add to the repository of the distribution as additive build content. The
+// rules are named by the import specifiers of the modules, and depend on the
+// rules for the modules they import, in the same distribution or in others,
+// with labels for other distributions given by the template. Sources are
+// relative to srcsPrefix, the directory in the repository where the wheel is
+// installed. The rule for the first module of a cycle has the sources of all
+// the modules in the cycle, and the other modules are aliases for it.
+func writeModuleBuildFiles(manifest []manifestEntry, dir, srcsPrefix, labelTemplate string) error {
+	byDist := make(map[string][]manifestEntry)
+	// Modules of all distributions, for imports of other distributions. The
+	// first distribution in the sorted manifest is chosen for conflicts.
+	allModules := make(map[string]manifestEntry)
+	for _, entry := range manifest {
+		byDist[entry.DistName] = append(byDist[entry.DistName], entry)
+		importSpec := entryImportSpec(entry)
+		if _, ok := allModules[importSpec]; !ok {
+			allModules[importSpec] = entry
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("creating directory for BUILD files: %w", err)
+	}
+	for dist, entries := range byDist {
+		buildPath := filepath.Join(dir, dist+".BUILD")
+		content := moduleBuildFile(buildPath, entries, allModules, srcsPrefix, labelTemplate)
+		if err := os.WriteFile(buildPath, content, 0o644); err != nil {
+			return fmt.Errorf("writing BUILD file for distribution %q: %w", dist, err)
+		}
+	}
+	return nil
+}
+
+// Returns the content of the BUILD file for the modules of a distribution.
+func moduleBuildFile(buildPath string, entries []manifestEntry, allModules map[string]manifestEntry, srcsPrefix, labelTemplate string) []byte {
+	f := rule.EmptyFile(buildPath, "")
+	distModules := make(map[string]manifestEntry, len(entries))
+	for _, entry := range entries {
+		distModules[entryImportSpec(entry)] = entry
+	}
+	for _, entry := range entries {
+		importSpec := entryImportSpec(entry)
+		if len(entry.Cycle) > 0 && entry.Cycle[0] != importSpec {
+			r := rule.NewRule("alias", importSpec)
+			r.SetAttr("actual", ":"+entry.Cycle[0])
+			r.SetAttr("visibility", []string{"//visibility:public"})
+			r.Insert(f)
+			continue
+		}
+		r := rule.NewRule("py_library", importSpec)
+		var srcs, data []string
+		for _, member := range cycleEntries(entry, distModules) {
+			src := path.Join(srcsPrefix, strings.ReplaceAll(member.Pkg, ".", "/"), path.Base(member.File))
+			if member.Type == "py" {
+				srcs = append(srcs, src)
+			} else {
+				data = append(data, src)
+			}
+		}
+		if len(srcs) > 0 {
+			r.SetAttr("srcs", srcs)
+		}
+		if len(data) > 0 {
+			r.SetAttr("data", data)
+		}
+		if srcsPrefix != "" {
+			r.SetAttr("imports", []string{path.Clean(srcsPrefix)})
+		}
+		deps := make(map[string]struct{})
+		for _, dep := range entry.Deps {
+			deps[":"+dep] = struct{}{}
+		}
+		for _, imp := range entry.ExImports {
+			if dep, ok := longestPrefix(imp, allModules); ok && allModules[dep].DistName != entry.DistName {
+				deps[moduleLabel(labelTemplate, allModules[dep].DistName, dep)] = struct{}{}
+			}
+		}
+		if len(deps) > 0 {
+			var labels []string
+			for dep := range deps {
+				labels = append(labels, dep)
+			}
+			sort.Strings(labels)
+			r.SetAttr("deps", labels)
+		}
+		r.SetAttr("visibility", []string{"//visibility:public"})
+		r.Insert(f)
+	}
+	// Sync the rules first so that the load is placed before them.
+	f.Sync()
+	load := rule.NewLoad("@rules_python//python:defs.bzl")
+	load.Add("py_library")
+	load.Insert(f, 0)
+	return append([]byte("# GENERATED FILE - DO NOT EDIT!\n\n"), f.Format()...)
+}
+
+// Returns the entries of the modules in the cycle of the entry, from the
+// modules of its distribution, or just the entry if it is not part of a cycle.
+func cycleEntries(entry manifestEntry, distModules map[string]manifestEntry) []manifestEntry {
+	if len(entry.Cycle) == 0 {
+		return []manifestEntry{entry}
+	}
+	var res []manifestEntry
+	for _, member := range entry.Cycle {
+		res = append(res, distModules[member])
+	}
+	return res
+}
+
+// Returns the label for a module in a distribution from the template, with
+// the same placeholders as in the Gazelle extension: {dist}, {normalized_dist}
+// and {module}.
+func moduleLabel(template, dist, importSpec string) string {
+	return strings.NewReplacer(
+		"{dist}", dist,
+		"{normalized_dist}", internal.NormalizeDistName(dist, internal.DistNameUnderscore),
+		"{module}", importSpec,
+	).Replace(template)
+}
+
+// Returns the import specifier of the module for the entry.
+func entryImportSpec(entry manifestEntry) string {
+	return internal.ImportSpec(strings.ReplaceAll(entry.Pkg, ".", "/"), entry.Module)
+}
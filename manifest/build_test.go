@@ -0,0 +1,124 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestModuleBuildFile(t *testing.T) {
+	entries := []manifestEntry{
+		{DistName: "big-dist", Pkg: "big", Type: "py", File: "big/__init__.py"},
+		{DistName: "big-dist", Pkg: "big", Module: "_native", Type: "so", File: "big/_native.so", Deps: []string{"big"}},
+		{DistName: "big-dist", Pkg: "big", Module: "core", Type: "py", File: "big-dist-1.0.data/purelib/big/core.py", Deps: []string{"big"}, ExImports: []string{"numpy.linalg", "os"}},
+	}
+	allModules := map[string]manifestEntry{
+		"numpy":        {DistName: "numpy", Pkg: "numpy", Type: "py", File: "numpy/__init__.py"},
+		"numpy.linalg": {DistName: "numpy", Pkg: "numpy.linalg", Type: "py", File: "numpy/linalg/__init__.py"},
+	}
+	for _, entry := range entries {
+		allModules[entryImportSpec(entry)] = entry
+	}
+	got := string(moduleBuildFile("big-dist.BUILD", entries, allModules, "site-packages", "@pip_{normalized_dist}//:{module}"))
+	want := `# GENERATED FILE - DO NOT EDIT!
+
+load("@rules_python//python:defs.bzl", "py_library")
+
+py_library(
+    name = "big",
+    srcs = ["site-packages/big/__init__.py"],
+    imports = ["site-packages"],
+    visibility = ["//visibility:public"],
+)
+
+py_library(
+    name = "big._native",
+    data = ["site-packages/big/_native.so"],
+    imports = ["site-packages"],
+    visibility = ["//visibility:public"],
+    deps = [":big"],
+)
+
+py_library(
+    name = "big.core",
+    srcs = ["site-packages/big/core.py"],
+    imports = ["site-packages"],
+    visibility = ["//visibility:public"],
+    deps = [
+        ":big",
+        "@pip_numpy//:numpy.linalg",
+    ],
+)
+`
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("(-got, +want):%s", diff)
+	}
+}
+
+func TestModuleBuildFileCycle(t *testing.T) {
+	cycle := []string{"pkg", "pkg._speedups", "pkg.core"}
+	entries := []manifestEntry{
+		{DistName: "pkg", Pkg: "pkg", Type: "py", File: "pkg/__init__.py", ExImports: []string{"numpy"}, Cycle: cycle},
+		{DistName: "pkg", Pkg: "pkg", Module: "_speedups", Type: "so", File: "pkg/_speedups.so", Deps: []string{"pkg"}, Cycle: cycle},
+		{DistName: "pkg", Pkg: "pkg", Module: "core", Type: "py", File: "pkg/core.py", Deps: []string{"pkg"}, Cycle: cycle},
+		{DistName: "pkg", Pkg: "pkg", Module: "util", Type: "py", File: "pkg/util.py", Deps: []string{"pkg"}},
+	}
+	allModules := map[string]manifestEntry{
+		"numpy": {DistName: "numpy", Pkg: "numpy", Type: "py", File: "numpy/__init__.py"},
+	}
+	for _, entry := range entries {
+		allModules[entryImportSpec(entry)] = entry
+	}
+	got := string(moduleBuildFile("pkg.BUILD", entries, allModules, "", "@pip_{normalized_dist}//:{module}"))
+	want := `# GENERATED FILE - DO NOT EDIT!
+
+load("@rules_python//python:defs.bzl", "py_library")
+
+py_library(
+    name = "pkg",
+    srcs = [
+        "pkg/__init__.py",
+        "pkg/core.py",
+    ],
+    data = ["pkg/_speedups.so"],
+    visibility = ["//visibility:public"],
+    deps = ["@pip_numpy//:numpy"],
+)
+
+alias(
+    name = "pkg._speedups",
+    actual = ":pkg",
+    visibility = ["//visibility:public"],
+)
+
+alias(
+    name = "pkg.core",
+    actual = ":pkg",
+    visibility = ["//visibility:public"],
+)
+
+py_library(
+    name = "pkg.util",
+    srcs = ["pkg/util.py"],
+    visibility = ["//visibility:public"],
+    deps = [":pkg"],
+)
+`
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("(-got, +want):%s", diff)
+	}
+}
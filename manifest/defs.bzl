@@ -9,6 +9,8 @@ def _modules_manifest_impl(ctx):
     args = ctx.actions.args()
     args.add("--output-path", manifest.path)
     args.add("--excluded-patterns", ",".join(ctx.attr.exclude_patterns))
+    if ctx.attr.module_deps:
+        args.add("--module-deps")
     args.add_all([whl.path for whl in ctx.files.wheels])
     ctx.actions.run(
         inputs = ctx.files.wheels,
@@ -61,6 +63,10 @@ _modules_manifest = rule(
             mandatory = False,
         ),
         "manifest": attr.output(mandatory = True),
+        "module_deps": attr.bool(
+            default = False,
+            doc = "Whether to also record the modules of the same distribution which each module depends on, for rules per module.",
+        ),
         "manifest_dst": attr.string(mandatory = True),
         "updater": attr.output(mandatory = True),
         "wheels": attr.label_list(
@@ -77,7 +83,7 @@ _modules_manifest = rule(
     doc = "Creates a TSV file for mapping module names to wheel distribution names.",
 )
 
-def python_external_modules_manifest(name, wheels, exclude_patterns, **kwargs):
+def python_external_modules_manifest(name, wheels, exclude_patterns, module_deps = False, **kwargs):
     """Rules for Python module manifest.
 
     Generating, updating a checked-in version, and testing the checked-in
@@ -87,6 +93,7 @@ def python_external_modules_manifest(name, wheels, exclude_patterns, **kwargs):
         name: Name of the manifest generation rule; other rule names are derivatives.
         wheels: List of wheel files for which to generate a manifest.
         exclude_patterns: File path patterns to exclude from within the wheels.
+        module_deps: Whether to record the dependencies between the modules of each distribution.
         **kwargs: Other general rule attributes
     """
     _manifest = name + ".internal.tsv"
@@ -98,6 +105,7 @@ def python_external_modules_manifest(name, wheels, exclude_patterns, **kwargs):
         name = name,
         wheels = wheels,
         exclude_patterns = exclude_patterns,
+        module_deps = module_deps,
         manifest = _manifest,
         manifest_dst = _manifest_dst,
         updater = _updater,
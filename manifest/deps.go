@@ -0,0 +1,197 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/siddharthab/bazel-gazelle-python/internal"
+	"github.com/siddharthab/bazel-gazelle-python/python/parser"
+)
+
+// Sets the Deps and ExImports of the entries for the modules of a
+// distribution, keyed by import specifier, from the imports in the Python
+// sources in the wheel. Modules depend on their parent package, and on the
+// modules for the longest prefix of each of their imports within the
+// distribution. Modules which can not be parsed conservatively depend on all
+// the other modules. The dependencies may be cyclical; see collapseCycles.
+func addModuleDeps(wheelPath string, entries map[string]manifestEntry) error {
+	r, err := zip.OpenReader(wheelPath)
+	if err != nil {
+		return fmt.Errorf("opening zip file at %q: %w", wheelPath, err)
+	}
+	defer r.Close()
+	files := make(map[string]*zip.File, len(r.File))
+	for _, f := range r.File {
+		files[f.Name] = f
+	}
+
+	for importSpec, entry := range entries {
+		deps := make(map[string]struct{})
+		if parent, ok := parentModule(importSpec, entries); ok {
+			deps[parent] = struct{}{}
+		}
+		var exImports []string
+		if f, ok := files[entry.File]; ok && entry.Type == "py" {
+			res, err := parseZipFile(f)
+			if err != nil {
+				log.Printf("parsing %q in %q; depending on all modules in the distribution: %v", entry.File, wheelPath, err)
+				for other := range entries {
+					deps[other] = struct{}{}
+				}
+			}
+			for _, imp := range res.Imports {
+				name, ok := absoluteImport(entry.Pkg, imp)
+				if !ok {
+					continue
+				}
+				if dep, ok := longestPrefix(name, entries); ok {
+					deps[dep] = struct{}{}
+				} else {
+					exImports = append(exImports, name)
+				}
+			}
+		}
+		delete(deps, importSpec)
+		entry.Deps = nil
+		for dep := range deps {
+			entry.Deps = append(entry.Deps, dep)
+		}
+		sort.Strings(entry.Deps)
+		entry.ExImports = exImports
+		entries[importSpec] = entry
+	}
+	return nil
+}
+
+// Collapses the modules of a distribution, keyed by import specifier, which
+// depend on each other cyclically, as Bazel does not allow cyclical
+// dependencies; e.g. a package __init__.py which imports its submodules, as
+// every module depends on its parent package. The first module of each cycle
+// by import specifier has the dependencies and external imports of the whole
+// cycle, the other modules depend only on it, and dependencies on the modules
+// of a cycle are replaced by dependencies on its first module.
+func collapseCycles(entries map[string]manifestEntry) {
+	var importSpecs []string
+	for importSpec := range entries {
+		importSpecs = append(importSpecs, importSpec)
+	}
+	sort.Strings(importSpecs)
+	cycles := internal.Cycles(importSpecs, func(importSpec string) []string {
+		return entries[importSpec].Deps
+	})
+	owners := make(map[string]string)
+	for _, cycle := range cycles {
+		for _, member := range cycle {
+			owners[member] = cycle[0]
+		}
+	}
+	for _, cycle := range cycles {
+		deps := make(map[string]struct{})
+		exImports := make(map[string]struct{})
+		for _, member := range cycle {
+			for _, dep := range entries[member].Deps {
+				deps[owners[dep]] = struct{}{}
+			}
+			for _, imp := range entries[member].ExImports {
+				exImports[imp] = struct{}{}
+			}
+		}
+		delete(deps, cycle[0])
+		for i, member := range cycle {
+			entry := entries[member]
+			if len(cycle) > 1 {
+				entry.Cycle = cycle
+			}
+			if i == 0 {
+				entry.Deps = sortedKeys(deps)
+				entry.ExImports = sortedKeys(exImports)
+			} else {
+				entry.Deps = []string{cycle[0]}
+				entry.ExImports = nil
+			}
+			entries[member] = entry
+		}
+	}
+}
+
+// Returns the keys of the set, sorted, or nil if empty.
+func sortedKeys(set map[string]struct{}) []string {
+	var res []string
+	for key := range set {
+		res = append(res, key)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func parseZipFile(f *zip.File) (parser.Result, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return parser.Result{}, err
+	}
+	defer rc.Close()
+	return parser.Parse(rc, f.Name)
+}
+
+// Returns the absolute import specifier for an import in a module in the
+// (dot separated) package pkg. Returns false if a relative import goes beyond
+// the top-level package.
+func absoluteImport(pkg string, imp parser.Import) (string, bool) {
+	if imp.Level == 0 {
+		return imp.Name, true
+	}
+	var anchor []string
+	if pkg != "" {
+		anchor = strings.Split(pkg, ".")
+	}
+	if imp.Level > len(anchor) {
+		return "", false
+	}
+	anchor = anchor[:len(anchor)-imp.Level+1]
+	if imp.Name != "" {
+		anchor = append(anchor, imp.Name)
+	}
+	return strings.Join(anchor, "."), true
+}
+
+// Returns the longest prefix of the import specifier, at a dot boundary,
+// which is a module in the entries.
+func longestPrefix(importSpec string, entries map[string]manifestEntry) (string, bool) {
+	for prefix := importSpec; prefix != ""; {
+		if _, ok := entries[prefix]; ok {
+			return prefix, true
+		}
+		i := strings.LastIndexByte(prefix, '.')
+		if i < 0 {
+			break
+		}
+		prefix = prefix[:i]
+	}
+	return "", false
+}
+
+// Returns the nearest ancestor package of the module which is in the entries.
+func parentModule(importSpec string, entries map[string]manifestEntry) (string, bool) {
+	i := strings.LastIndexByte(importSpec, '.')
+	if i < 0 {
+		return "", false
+	}
+	return longestPrefix(importSpec[:i], entries)
+}
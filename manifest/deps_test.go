@@ -0,0 +1,158 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/siddharthab/bazel-gazelle-python/python/parser"
+)
+
+// Writes a wheel with the given files, keyed by name, in the directory.
+func writeWheel(t *testing.T, dir, name string, files map[string]string) string {
+	t.Helper()
+	wheelPath := filepath.Join(dir, name)
+	f, err := os.Create(wheelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return wheelPath
+}
+
+func TestAnalyzeWheelModuleDeps(t *testing.T) {
+	wheelPath := writeWheel(t, t.TempDir(), "bigdist-1.0-py3-none-any.whl", map[string]string{
+		"big/__init__.py":              "from .core import run\n",
+		"big/core.py":                  "import numpy\n\nfrom big.layers import dense\nfrom . import util\n",
+		"big/util.py":                  "import os\n",
+		"big/layers/__init__.py":       "",
+		"big/layers/dense.py":          "from ..util import helper\nfrom ...beyond import x\n",
+		"big/layers/broken.py":         "def (\n",
+		"big/_native.so":               "",
+		"bigdist-1.0.dist-info/RECORD": "",
+	})
+	got, err := analyzeWheel(wheelPath, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := make(map[string][]string)
+	exImports := make(map[string][]string)
+	cycles := make(map[string][]string)
+	for _, entry := range got {
+		deps[entryImportSpec(entry)] = entry.Deps
+		if len(entry.ExImports) > 0 {
+			exImports[entryImportSpec(entry)] = entry.ExImports
+		}
+		if len(entry.Cycle) > 0 {
+			cycles[entryImportSpec(entry)] = entry.Cycle
+		}
+	}
+	// big imports big.core, which imports big.util and, through
+	// big.layers.dense, big.layers; all of them depend on big.
+	cycle := []string{"big", "big.core", "big.layers", "big.layers.dense", "big.util"}
+	wantDeps := map[string][]string{
+		"big":               nil,
+		"big._native":       {"big"},
+		"big.core":          {"big"},
+		"big.util":          {"big"},
+		"big.layers":        {"big"},
+		"big.layers.dense":  {"big"},
+		"big.layers.broken": {"big", "big._native"},
+	}
+	if diff := cmp.Diff(deps, wantDeps); diff != "" {
+		t.Errorf("deps (-got, +want):%s", diff)
+	}
+	wantCycles := map[string][]string{
+		"big":              cycle,
+		"big.core":         cycle,
+		"big.util":         cycle,
+		"big.layers":       cycle,
+		"big.layers.dense": cycle,
+	}
+	if diff := cmp.Diff(cycles, wantCycles); diff != "" {
+		t.Errorf("cycles (-got, +want):%s", diff)
+	}
+	wantExImports := map[string][]string{
+		"big": {"numpy", "os"},
+	}
+	if diff := cmp.Diff(exImports, wantExImports); diff != "" {
+		t.Errorf("external imports (-got, +want):%s", diff)
+	}
+}
+
+func TestCollapseCycles(t *testing.T) {
+	// pkg/__init__.py: from .core import x
+	entries := map[string]manifestEntry{
+		"pkg":       {Pkg: "pkg", Type: "py", Deps: []string{"pkg.core"}},
+		"pkg.core":  {Pkg: "pkg", Module: "core", Type: "py", Deps: []string{"pkg"}, ExImports: []string{"os"}},
+		"pkg.extra": {Pkg: "pkg", Module: "extra", Type: "py", Deps: []string{"pkg", "pkg.core"}},
+	}
+	collapseCycles(entries)
+	want := map[string]manifestEntry{
+		"pkg":       {Pkg: "pkg", Type: "py", ExImports: []string{"os"}, Cycle: []string{"pkg", "pkg.core"}},
+		"pkg.core":  {Pkg: "pkg", Module: "core", Type: "py", Deps: []string{"pkg"}, Cycle: []string{"pkg", "pkg.core"}},
+		"pkg.extra": {Pkg: "pkg", Module: "extra", Type: "py", Deps: []string{"pkg"}},
+	}
+	if diff := cmp.Diff(entries, want); diff != "" {
+		t.Errorf("(-got, +want):%s", diff)
+	}
+}
+
+func TestAbsoluteImport(t *testing.T) {
+	testCases := []struct {
+		pkg  string
+		imp  parser.Import
+		want string
+		ok   bool
+	}{
+		{"a.b", parser.Import{Name: "x.y"}, "x.y", true},
+		{"a.b", parser.Import{Name: "c", Level: 1}, "a.b.c", true},
+		{"a.b", parser.Import{Name: "c", Level: 2}, "a.c", true},
+		{"a.b", parser.Import{Name: "", Level: 2}, "a", true},
+		{"a.b", parser.Import{Name: "c", Level: 3}, "", false},
+		{"", parser.Import{Name: "c", Level: 1}, "", false},
+	}
+	for i, testCase := range testCases {
+		got, ok := absoluteImport(testCase.pkg, testCase.imp)
+		if got != testCase.want || ok != testCase.ok {
+			t.Errorf("test %d: got (%q, %v), want (%q, %v)", i, got, ok, testCase.want, testCase.ok)
+		}
+	}
+}
@@ -28,27 +28,47 @@ import (
 var (
 	outputPath       = flag.String("output-path", "", "Output file path; if empty, will output to stdout")
 	excludedPatterns = flag.String("excluded-patterns", "", "File patterns to exclude (comma-separated)")
+	moduleDeps       = flag.Bool("module-deps", false, "Parse the Python modules in the wheels, and output the modules of the same distribution which each module depends on")
+	moduleBuildDir   = flag.String("module-build-dir", "", "Directory to write a BUILD file for each distribution with a rule for each module; implies -module-deps")
+	moduleSrcsPrefix = flag.String("module-srcs-prefix", "site-packages", "Directory in the repository of a distribution where its wheel is installed, for the sources of the rules for modules")
+	moduleLabels     = flag.String("module-label-template", "@pip_{normalized_dist}//:{module}", "Template for labels of the rules for modules in other distributions, with placeholders {dist}, {normalized_dist} and {module}")
 )
 
 // Analyze the given wheels (paths taken as command args) and output a TSV (on
-// stdout) of distribution name, pkg path (dot separated), module name and
-// module type (py or so) in the installation. It does so without unzipping the
-// wheels so should be very fast (<1s for ~100 wheels).
+// stdout) of distribution name (normalized as per PEP 503), pkg path (dot
+// separated), module name and module type (py or so) in the installation. It
+// does so without unzipping the wheels so should be very fast (<1s for ~100
+// wheels).
+//
+// With -module-deps, the Python modules are also parsed, and a fifth column
+// lists the modules (comma separated import specifiers) in the same
+// distribution which each module depends on. This graph allows for a rule per
+// module in the repositories of large distributions, written with
+// -module-build-dir. Cycles in the graph are collapsed into their first
+// module, which the other modules of the cycle depend on.
 func main() {
 	flag.Parse()
+	if *moduleBuildDir != "" {
+		*moduleDeps = true
+	}
 	excludedRegex := compilePatterns(strings.Split(*excludedPatterns, ","))
 	wheelPaths := flag.Args()
 
 	var manifest []manifestEntry
 	for _, path := range wheelPaths {
-		entries, err := analyzeWheel(path, excludedRegex)
+		entries, err := analyzeWheel(path, excludedRegex, *moduleDeps)
 		if err != nil {
 			log.Fatalf("collecting manifest entries for %q: %v", path, err)
 		}
 		manifest = append(manifest, entries...)
 	}
 	sortManifest(manifest)
-	writeManifest(manifest, *outputPath)
+	writeManifest(manifest, *outputPath, *moduleDeps)
+	if *moduleBuildDir != "" {
+		if err := writeModuleBuildFiles(manifest, *moduleBuildDir, *moduleSrcsPrefix, *moduleLabels); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
 
 func compilePatterns(patterns []string) []*regexp.Regexp {
@@ -85,7 +105,7 @@ func sortManifest(manifest []manifestEntry) {
 	})
 }
 
-func writeManifest(manifest []manifestEntry, path string) {
+func writeManifest(manifest []manifestEntry, path string, moduleDeps bool) {
 	f := os.Stdout
 	if path != "" {
 		var err error
@@ -106,7 +126,11 @@ func writeManifest(manifest []manifestEntry, path string) {
 	w := csv.NewWriter(f)
 	w.Comma = '\t'
 	for _, entry := range manifest {
-		if err := w.Write([]string{entry.DistName, entry.Pkg, entry.Module, entry.Type}); err != nil {
+		record := []string{entry.DistName, entry.Pkg, entry.Module, entry.Type}
+		if moduleDeps {
+			record = append(record, strings.Join(entry.Deps, ","))
+		}
+		if err := w.Write(record); err != nil {
 			log.Fatalf("writing manifest record: %v", err)
 		}
 	}
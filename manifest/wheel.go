@@ -32,15 +32,34 @@ type manifestEntry struct {
 	DistName    string
 	Pkg, Module string
 	Type        string
+	File        string // Path of the module in the wheel.
+	// Import specifiers of the modules in the same distribution which this
+	// module depends on, if computed. Modules in a cycle depend only on the
+	// first module of the cycle, and dependencies on a cycle are on its first
+	// module; see collapseCycles.
+	Deps []string
+	// Absolute imports of this module which are not satisfied within the
+	// distribution, if computed. For a cycle, these are on its first module.
+	ExImports []string
+	// Import specifiers of the modules which depend on each other cyclically
+	// with this module, sorted, including this module; nil if it is not part of
+	// a cycle. The rule for the first module has the sources of all of them.
+	Cycle []string
 }
 
-func analyzeWheel(wheelPath string, excludedPatterns []*regexp.Regexp) ([]manifestEntry, error) {
+// Returns the manifest entries for the modules in the wheel, with the
+// dependencies between the modules if moduleDeps is true.
+func analyzeWheel(wheelPath string, excludedPatterns []*regexp.Regexp, moduleDeps bool) ([]manifestEntry, error) {
 	distName, distVersion, err := parseWheelName(path.Base(wheelPath))
 	if err != nil {
 		return nil, fmt.Errorf("analyzing wheel path %q: %w", wheelPath, err)
 	}
-	distInfoDir := fmt.Sprintf("%s-%s.dist-info", distName, distVersion)
-	dataDir := fmt.Sprintf("%s-%s.data", distName, distVersion)
+	// Directory names are compared in lower case.
+	distInfoDir := strings.ToLower(fmt.Sprintf("%s-%s.dist-info", distName, distVersion))
+	dataDir := strings.ToLower(fmt.Sprintf("%s-%s.data", distName, distVersion))
+	// The manifest records the names normalized as per PEP 503, which may be
+	// given in other styles when generating labels.
+	distName = internal.NormalizeDistName(distName, internal.DistNameDash)
 
 	files, err := listFilesInZip(wheelPath, excludedPatterns)
 	if err != nil {
@@ -50,9 +69,15 @@ func analyzeWheel(wheelPath string, excludedPatterns []*regexp.Regexp) ([]manife
 	entryMap := make(map[string]manifestEntry)
 	for _, name := range files {
 		if pkg, module, typ, importSpec := moduleForFilename(name, distInfoDir, dataDir); pkg != "" || module != "" {
-			entryMap[importSpec] = manifestEntry{distName, pkg, module, typ}
+			entryMap[importSpec] = manifestEntry{DistName: distName, Pkg: pkg, Module: module, Type: typ, File: name}
 		}
 	}
+	if moduleDeps {
+		if err := addModuleDeps(wheelPath, entryMap); err != nil {
+			return nil, err
+		}
+		collapseCycles(entryMap)
+	}
 
 	var manifestEntries []manifestEntry
 	for _, entry := range entryMap {
@@ -117,7 +142,9 @@ func moduleForFilename(name, distInfoDir, dataDir string) (pkg, module, typ, imp
 	pkg = strings.Join(components[:len(components)-1], ".")
 	module, typ, ok := internal.ModuleName(components[len(components)-1])
 	if ok {
-		importSpec = internal.ImportSpec(pkgPath, module)
+		if pkgPath != "" || module != "" {
+			importSpec = internal.ImportSpec(pkgPath, module)
+		}
 		return pkg, module, typ, importSpec
 	}
 	return "", "", "", ""
@@ -14,7 +14,15 @@
 
 package main
 
-import "testing"
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
 
 func TestParseWheelName(t *testing.T) {
 	testCases := []struct {
@@ -71,7 +79,7 @@ func TestModuleForFilename(t *testing.T) {
 	}
 
 	for i, testCase := range testCases {
-		gotPkg, gotModule, gotType := moduleForFilename(testCase.filename, testCase.distInfoDir, testCase.dataDir)
+		gotPkg, gotModule, gotType, _ := moduleForFilename(testCase.filename, testCase.distInfoDir, testCase.dataDir)
 		if got, want := gotPkg, testCase.wantPkg; got != want {
 			t.Errorf("test %d: %s != %s", i, got, want)
 		}
@@ -83,3 +91,43 @@ func TestModuleForFilename(t *testing.T) {
 		}
 	}
 }
+
+func TestAnalyzeWheel(t *testing.T) {
+	wheelPath := filepath.Join(t.TempDir(), "ruamel.yaml-0.17.21-py3-none-any.whl")
+	f, err := os.Create(wheelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	for _, name := range []string{
+		"ruamel/yaml/__init__.py",
+		"ruamel/yaml/main.py",
+		"ruamel.yaml-0.17.21.dist-info/METADATA",
+		"ruamel.yaml-0.17.21.data/purelib/ruamel/yaml/extra.py",
+		"ruamel.yaml-0.17.21.data/scripts/tool.py",
+	} {
+		if _, err := w.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := analyzeWheel(wheelPath, nil, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].Module < got[j].Module })
+	want := []manifestEntry{
+		{DistName: "ruamel-yaml", Pkg: "ruamel.yaml", Module: "", Type: "py", File: "ruamel/yaml/__init__.py"},
+		{DistName: "ruamel-yaml", Pkg: "ruamel.yaml", Module: "extra", Type: "py", File: "ruamel.yaml-0.17.21.data/purelib/ruamel/yaml/extra.py"},
+		{DistName: "ruamel-yaml", Pkg: "ruamel.yaml", Module: "main", Type: "py", File: "ruamel/yaml/main.py"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("(-got, +want):%s", diff)
+	}
+}
@@ -17,8 +17,11 @@ package python
 import (
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"sync"
 
 	"github.com/siddharthab/bazel-gazelle-python/internal"
 	"github.com/siddharthab/bazel-gazelle-python/python/parser"
@@ -26,55 +29,108 @@ import (
 
 // Analyzes the Python package with slash separated path given by pkgPath,
 // located at absPath dir in the system with the given subDirs, and comprised of
-// files given by filenames. Returns a sorted list of Python modules.
-func analyzePythonPackage(pkgPath, absPath string, subDirs, filenames []string) []*Module {
-	// TODO: Parallelize this if this is slow.
+// files given by filenames. Modules with names matching testPatterns are tests.
+// Up to parallelism files are parsed concurrently; if not positive, then
+// GOMAXPROCS. Parse results are cached in cache, if not nil. If
+// namespacePackages, subdirectories without __init__.py are also subpackages.
+// Modules with the `# gazelle:py_ignore_file` pragma are skipped. Returns a
+// sorted list of Python modules.
+func analyzePythonPackage(pkgPath, absPath string, subDirs, filenames, testPatterns []string, parallelism int, cache *parser.Cache, namespacePackages bool) []*Module {
 	var (
 		importSpecs []string
 		moduleMap   = make(map[string]*Module) // Keyed by import specifier.
 	)
+	var pyFilenames []string
 	for _, filename := range filenames {
-		moduleName, moduleType, ok := internal.ModuleName(filename)
-		if !ok || moduleType != "py" {
+		if _, moduleType, ok := internal.ModuleName(filename); ok && moduleType == "py" {
+			pyFilenames = append(pyFilenames, filename)
+		}
+	}
+	results, errs := parseFiles(absPath, pyFilenames, parallelism, cache)
+	for i, filename := range pyFilenames {
+		if errs[i] != nil {
+			log.Printf("unable to generate rule for Python module: %v", errs[i])
 			continue
 		}
-		path := filepath.Join(absPath, filename)
-		res, err := parser.ParsePath(path)
-		if err != nil {
-			log.Printf("unable to generate rule for Python module: %v", err)
+		if results[i].IgnoreFile {
 			continue
 		}
+		switch kind := results[i].RuleKind; kind {
+		case "", kindPyLibrary, kindPyBinary, kindPyTest:
+		default:
+			log.Printf("invalid rule kind %q in Python module %q: expected %q, %q or %q", kind, path.Join(pkgPath, filename), kindPyLibrary, kindPyBinary, kindPyTest)
+			results[i].RuleKind = ""
+		}
+		moduleName, _ := internal.MustModuleName(filename)
 		importSpec := internal.ImportSpec(pkgPath, moduleName)
 		importSpecs = append(importSpecs, importSpec)
 		moduleMap[importSpec] = &Module{
-			Result:     res,
+			Result:     results[i],
 			ImportSpec: importSpec,
 			PkgPath:    pkgPath,
 			Name:       moduleName,
 			Filename:   filename,
+			IsTest:     matchesAny(moduleName, testPatterns),
 			InPkgDeps:  make(map[*Module]struct{}),
 		}
 	}
 	subPackages := make(map[string]struct{})
 	for _, subdir := range subDirs {
-		if _, err := os.Stat(filepath.Join(absPath, subdir, "__init__.py")); err == nil {
+		if _, err := os.Stat(filepath.Join(absPath, subdir, "__init__.py")); err == nil || namespacePackages {
 			subPackages[subdir] = struct{}{}
 		}
 	}
 
-	// Compute transitive closures of dependencies within the package.
+	// Compute dependencies within the package, and the cycles among them.
 	for _, module := range moduleMap {
 		module.ProcessImports(moduleMap, subPackages)
 	}
-	for _, module := range moduleMap {
-		module.DepsClosure()
-	}
-
-	// Sort the modules by name and return.
 	sort.Strings(importSpecs)
 	var res []*Module
 	for _, spec := range importSpecs {
 		res = append(res, moduleMap[spec])
 	}
+	ComputeCycles(res)
 	return res
 }
+
+// Parses the files in the directory at absPath with a pool of up to
+// parallelism workers; if not positive, then GOMAXPROCS. Returns the results
+// and the errors in the same order as the filenames.
+func parseFiles(absPath string, filenames []string, parallelism int, cache *parser.Cache) ([]parser.Result, []error) {
+	if parallelism <= 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+	if parallelism > len(filenames) {
+		parallelism = len(filenames)
+	}
+	results := make([]parser.Result, len(filenames))
+	errs := make([]error, len(filenames))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				results[i], errs[i] = cache.ParsePath(filepath.Join(absPath, filenames[i]))
+			}
+		}()
+	}
+	for i := range filenames {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	return results, errs
+}
+
+// Returns true if the name matches any of the patterns.
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package python
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/siddharthab/bazel-gazelle-python/python/parser"
+)
+
+// Writes a synthetic package of n modules, each importing a few sibling and
+// external modules, and returns the file names.
+func writeSyntheticPackage(tb testing.TB, dir string, n int) []string {
+	var filenames []string
+	for i := 0; i < n; i++ {
+		var content strings.Builder
+		content.WriteString("import os\nimport yaml\n\n")
+		for j := 1; j <= 3; j++ {
+			fmt.Fprintf(&content, "from . import mod%d\n", (i+j)%n)
+		}
+		fmt.Fprintf(&content, "\n\nclass Class%d:\n", i)
+		for j := 0; j < 20; j++ {
+			fmt.Fprintf(&content, "    def method%d(self, x, y={'a': (1, 2)}):\n        return [z for z in range(x) if z %% 2]\n\n", j)
+		}
+		filename := fmt.Sprintf("mod%d.py", i)
+		if err := os.WriteFile(filepath.Join(dir, filename), []byte(content.String()), 0o644); err != nil {
+			tb.Fatal(err)
+		}
+		filenames = append(filenames, filename)
+	}
+	return filenames
+}
+
+// Summarizes the modules as their import specifiers mapped to their sorted
+// dependencies within and outside the package.
+func summarizeModules(modules []*Module) []string {
+	var res []string
+	for _, module := range modules {
+		var deps []string
+		for dep := range module.InPkgDeps {
+			deps = append(deps, dep.ImportSpec)
+		}
+		for _, imp := range module.ExPkgImports {
+			deps = append(deps, imp.Name)
+		}
+		sort.Strings(deps)
+		res = append(res, module.ImportSpec+": "+strings.Join(deps, " "))
+	}
+	return res
+}
+
+func TestAnalyzePythonPackageParallel(t *testing.T) {
+	dir := t.TempDir()
+	filenames := writeSyntheticPackage(t, dir, 50)
+	// A file which can not be parsed is skipped.
+	if err := os.Mkdir(filepath.Join(dir, "broken.py"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	filenames = append(filenames, "broken.py", "README.md")
+
+	want := summarizeModules(analyzePythonPackage("pkg", dir, nil, filenames, nil, 1, nil, false))
+	if len(want) != 50 {
+		t.Fatalf("got %d modules, want 50", len(want))
+	}
+	for _, parallelism := range []int{0, 2, 8, 100} {
+		got := summarizeModules(analyzePythonPackage("pkg", dir, nil, filenames, nil, parallelism, nil, false))
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("parallelism %d: (-got, +want):%s", parallelism, diff)
+		}
+	}
+}
+
+func BenchmarkAnalyzePythonPackage(b *testing.B) {
+	dir := b.TempDir()
+	filenames := writeSyntheticPackage(b, dir, 500)
+	for _, parallelism := range []int{1, runtime.GOMAXPROCS(0)} {
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				analyzePythonPackage("pkg", dir, nil, filenames, nil, parallelism, nil, false)
+			}
+		})
+	}
+	b.Run("cached", func(b *testing.B) {
+		cache, err := parser.NewCache(b.TempDir())
+		if err != nil {
+			b.Fatal(err)
+		}
+		analyzePythonPackage("pkg", dir, nil, filenames, nil, 0, cache, false)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			analyzePythonPackage("pkg", dir, nil, filenames, nil, 0, cache, false)
+		}
+	})
+}
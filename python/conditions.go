@@ -0,0 +1,93 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package python
+
+import "strings"
+
+// Key of the default branch of a select() expression.
+const conditionDefault = "//conditions:default"
+
+// Returns the label of the setting for the condition of an import, and whether
+// the condition is the negation of the condition of the setting, e.g. for the
+// else branch of an if statement. Returns false if the import is not
+// conditional, or there is no setting for the condition.
+func (config *Configuration) conditionSetting(cond string) (string, bool, bool) {
+	if cond == "" {
+		return "", false, false
+	}
+	if setting, ok := config.ConditionSettings[cond]; ok {
+		return setting, false, true
+	}
+	if negated := strings.TrimPrefix(cond, "not "); negated != cond && !strings.Contains(negated, " and ") {
+		if setting, ok := config.ConditionSettings[negated]; ok {
+			return setting, true, true
+		}
+	}
+	return "", false, false
+}
+
+// conditionalDeps are the dependencies of a rule which are imported under
+// conditions on the Python version or the platform, keyed by the label of the
+// setting for the condition.
+type conditionalDeps map[string]*settingDeps
+
+// settingDeps are the dependencies imported under the condition of a setting,
+// and under its negation.
+type settingDeps struct {
+	matched   map[dependency]struct{}
+	unmatched map[dependency]struct{}
+}
+
+func (c conditionalDeps) add(setting string, negated bool, dep dependency) {
+	deps, ok := c[setting]
+	if !ok {
+		deps = &settingDeps{matched: make(map[dependency]struct{}), unmatched: make(map[dependency]struct{})}
+		c[setting] = deps
+	}
+	if negated {
+		deps.unmatched[dep] = struct{}{}
+	} else {
+		deps.matched[dep] = struct{}{}
+	}
+}
+
+// Removes the dependency under all conditions, and the settings left without
+// dependencies.
+func (c conditionalDeps) delete(dep dependency) {
+	for setting, deps := range c {
+		delete(deps.matched, dep)
+		delete(deps.unmatched, dep)
+		if len(deps.matched) == 0 && len(deps.unmatched) == 0 {
+			delete(c, setting)
+		}
+	}
+}
+
+// Returns the conditional dependencies together with the unconditional ones.
+func (c conditionalDeps) union(deps map[dependency]struct{}) map[dependency]struct{} {
+	res := make(map[dependency]struct{}, len(deps))
+	for dep := range deps {
+		res[dep] = struct{}{}
+	}
+	for _, settingDeps := range c {
+		for dep := range settingDeps.matched {
+			res[dep] = struct{}{}
+		}
+		for dep := range settingDeps.unmatched {
+			res[dep] = struct{}{}
+		}
+	}
+	return res
+}
@@ -0,0 +1,65 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package python
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConditionSetting(t *testing.T) {
+	config := Configuration{ConditionSettings: map[string]string{
+		`sys.platform == "win32"`:     "@platforms//os:windows",
+		"sys.version_info >= (3, 11)": "//python:py311",
+	}}
+	testCases := []struct {
+		cond        string
+		wantSetting string
+		wantNegated bool
+		wantOK      bool
+	}{
+		{"", "", false, false},
+		{`sys.platform == "win32"`, "@platforms//os:windows", false, true},
+		{`not sys.platform == "win32"`, "@platforms//os:windows", true, true},
+		{"not sys.version_info >= (3, 11)", "//python:py311", true, true},
+		{"sys.version_info < (3, 11)", "", false, false},
+		{`not sys.platform == "win32" and sys.version_info >= (3, 11)`, "", false, false},
+	}
+	for i, tc := range testCases {
+		setting, negated, ok := config.conditionSetting(tc.cond)
+		if setting != tc.wantSetting || negated != tc.wantNegated || ok != tc.wantOK {
+			t.Errorf("test %d: got (%q, %t, %t), want (%q, %t, %t)", i, setting, negated, ok, tc.wantSetting, tc.wantNegated, tc.wantOK)
+		}
+	}
+}
+
+func TestConditionalDeps(t *testing.T) {
+	tomllib, tomli, winreg := dependency{Label: "//:tomllib"}, dependency{Label: "@pip//tomli"}, dependency{Label: "//:winreg"}
+	selects := make(conditionalDeps)
+	selects.add("//python:py311", false, tomllib)
+	selects.add("//python:py311", true, tomli)
+	selects.add("@platforms//os:windows", false, winreg)
+	selects.delete(winreg)
+
+	if _, ok := selects["@platforms//os:windows"]; ok {
+		t.Errorf("setting without dependencies was not removed")
+	}
+	got := selects.union(map[dependency]struct{}{winreg: {}})
+	want := map[dependency]struct{}{tomllib: {}, tomli: {}, winreg: {}}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("(-got, +want):%s", diff)
+	}
+}
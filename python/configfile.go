@@ -0,0 +1,417 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package python
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/siddharthab/bazel-gazelle-python/internal"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Supported versions of the configuration file format.
+const configFileVersion = 1
+
+// configFile is the schema of the YAML configuration file for the extension,
+// given by the -py-config flag or the py_config directive. For example:
+//
+//	version: 1
+//	root_dir: src
+//	roots: [src, tools/python]
+//	namespace_packages: true
+//	name_template: "{module_name}"
+//	external_repo_name_prefix: pip_
+//	external_label_template: "@{prefix}{normalized_dist}//:pkg"
+//	dist_name_style: underscore
+//	requirement_load: "@pip//:requirements.bzl"
+//	internal_module_list_path: internal_modules.txt
+//	python_version: "3.11"
+//	internal_modules: [sitecustomize]
+//	external_module_map_path: external_modules.tsv
+//	requirements_path: requirements.txt
+//	external_modules:
+//	  yaml: PyYAML
+//	distribution_labels:
+//	  PyYAML: "//third_party/pyyaml"
+//	external_module_providers:
+//	  zope: zope.interface
+//	test_patterns: ["test_*", "*_test"]
+//	strict: true
+//	optional_imports: if_resolvable
+//	type_only_deps_attr: pyi_deps
+//	condition_settings:
+//	  sys.platform == "win32": "@platforms//os:windows"
+//
+// Paths are relative to the directory of the configuration file. Fields which
+// are not set keep the configuration inherited from the parent directory.
+// Entries in internal_modules, external_modules, distribution_labels,
+// external_module_providers and condition_settings are added to the inherited
+// ones; other fields replace the inherited values.
+type configFile struct {
+	Version                 int               `yaml:"version"`
+	RootDir                 *string           `yaml:"root_dir"`
+	Roots                   []string          `yaml:"roots"`
+	NamespacePackages       *bool             `yaml:"namespace_packages"`
+	NameTemplate            *string           `yaml:"name_template"`
+	ExternalRepoNamePrefix  *string           `yaml:"external_repo_name_prefix"`
+	ExternalLabelTemplate   *string           `yaml:"external_label_template"`
+	ModuleLabelTemplate     *string           `yaml:"external_module_label_template"`
+	DistNameStyle           *string           `yaml:"dist_name_style"`
+	RequirementLoad         *string           `yaml:"requirement_load"`
+	InternalModuleListPath  *string           `yaml:"internal_module_list_path"`
+	PythonVersion           *string           `yaml:"python_version"`
+	InternalModules         []string          `yaml:"internal_modules"`
+	ExternalModuleMapPath   *string           `yaml:"external_module_map_path"`
+	ExternalModuleMapName   *string           `yaml:"external_module_map_name"`
+	RequirementsPath        *string           `yaml:"requirements_path"`
+	ExternalModules         map[string]string `yaml:"external_modules"` // Import specifier to distribution name.
+	DistributionLabels      map[string]string `yaml:"distribution_labels"`
+	ExternalModuleProviders map[string]string `yaml:"external_module_providers"` // Import specifier to distribution name.
+	TestPatterns            []string          `yaml:"test_patterns"`
+	Strict                  *bool             `yaml:"strict"`
+	OptionalImports         *string           `yaml:"optional_imports"`
+	TypeOnlyDepsAttr        *string           `yaml:"type_only_deps_attr"`
+	ConditionSettings       map[string]string `yaml:"condition_settings"` // Condition to label.
+}
+
+func readConfigFilePath(path string) (*configFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening Python configuration file: %w", err)
+	}
+	defer f.Close()
+
+	res, err := readConfigFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Python configuration file %q: %w", path, err)
+	}
+	return res, nil
+}
+
+// Reads and validates the configuration file. Errors are reported with the
+// line numbers in the file.
+func readConfigFile(r io.Reader) (*configFile, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var cf configFile
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cf); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("missing version")
+		}
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	if err := cf.validate(&doc); err != nil {
+		return nil, err
+	}
+	return &cf, nil
+}
+
+// Validates the values in the configuration file, with the parsed document
+// used to report line numbers.
+func (cf *configFile) validate(doc *yaml.Node) error {
+	lineErr := func(keys []string, format string, args ...interface{}) error {
+		return fmt.Errorf("line %d: %s", nodeLine(doc, keys...), fmt.Sprintf(format, args...))
+	}
+	if cf.Version != configFileVersion {
+		if cf.Version == 0 {
+			return lineErr(nil, "missing version")
+		}
+		return lineErr([]string{"version"}, "unsupported version %d; supported versions: %d", cf.Version, configFileVersion)
+	}
+	if cf.RootDir != nil {
+		if p := *cf.RootDir; path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+			return lineErr([]string{"root_dir"}, "root_dir %q must be within the directory of the configuration file", p)
+		}
+	}
+	for _, p := range cf.Roots {
+		if path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+			return lineErr([]string{"roots"}, "root %q must be within the directory of the configuration file", p)
+		}
+	}
+	if cf.NameTemplate != nil && !strings.Contains(*cf.NameTemplate, "{module_name}") {
+		return lineErr([]string{"name_template"}, "name_template %q must contain {module_name}", *cf.NameTemplate)
+	}
+	if cf.ExternalLabelTemplate != nil {
+		if err := (labelTemplate{Template: *cf.ExternalLabelTemplate}).validate(); err != nil {
+			return lineErr([]string{"external_label_template"}, "%v", err)
+		}
+	}
+	if cf.ModuleLabelTemplate != nil && *cf.ModuleLabelTemplate != "" {
+		if err := (labelTemplate{Template: *cf.ModuleLabelTemplate}).validate(); err != nil {
+			return lineErr([]string{"external_module_label_template"}, "%v", err)
+		}
+	}
+	if cf.DistNameStyle != nil {
+		if _, err := internal.ParseDistNameStyle(*cf.DistNameStyle); err != nil {
+			return lineErr([]string{"dist_name_style"}, "%v", err)
+		}
+	}
+	if cf.OptionalImports != nil {
+		if _, err := parseOptionalImportPolicy(*cf.OptionalImports); err != nil {
+			return lineErr([]string{"optional_imports"}, "%v", err)
+		}
+	}
+	if cf.TypeOnlyDepsAttr != nil {
+		if err := validateTypeOnlyDepsAttr(*cf.TypeOnlyDepsAttr); err != nil {
+			return lineErr([]string{"type_only_deps_attr"}, "%v", err)
+		}
+	}
+	if cf.RequirementLoad != nil && *cf.RequirementLoad != "" {
+		if _, err := label.Parse(*cf.RequirementLoad); err != nil {
+			return lineErr([]string{"requirement_load"}, "invalid label %q: %v", *cf.RequirementLoad, err)
+		}
+	}
+	if cf.PythonVersion != nil && *cf.PythonVersion != "" {
+		if _, err := internal.StdlibModules(*cf.PythonVersion); err != nil {
+			return lineErr([]string{"python_version"}, "%v", err)
+		}
+	}
+	for _, module := range cf.InternalModules {
+		if module == "" {
+			return lineErr([]string{"internal_modules"}, "empty module name")
+		}
+	}
+	for imp, dist := range cf.ExternalModules {
+		if imp == "" || dist == "" {
+			return lineErr([]string{"external_modules", imp}, "empty import specifier or distribution name")
+		}
+	}
+	for imp, dist := range cf.ExternalModuleProviders {
+		if imp == "" || dist == "" {
+			return lineErr([]string{"external_module_providers", imp}, "empty import specifier or distribution name")
+		}
+	}
+	for dist, target := range cf.DistributionLabels {
+		if _, err := label.Parse(target); err != nil {
+			return lineErr([]string{"distribution_labels", dist}, "invalid label for distribution %q: %v", dist, err)
+		}
+	}
+	for cond, setting := range cf.ConditionSettings {
+		if _, _, err := parseConditionSetting(cond, setting, ""); err != nil {
+			return lineErr([]string{"condition_settings", cond}, "invalid condition setting: %v", err)
+		}
+	}
+	for _, pattern := range cf.TestPatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return lineErr([]string{"test_patterns"}, "invalid test pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Returns the line of the value for the path of keys in nested mappings of the
+// document, or the line of the closest parent found.
+func nodeLine(doc *yaml.Node, keys ...string) int {
+	node := doc
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line := node.Line
+	for _, key := range keys {
+		if node.Kind != yaml.MappingNode {
+			break
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				next = node.Content[i+1]
+				line = node.Content[i].Line
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return line
+}
+
+// Applies the configuration file at the given path, relative to the
+// repository root, over the configuration.
+func (config *Configuration) applyConfigFile(repoRoot, configPath string) error {
+	cf, err := readConfigFilePath(filepath.Join(repoRoot, configPath))
+	if err != nil {
+		return err
+	}
+	dir := path.Dir(configPath)
+	if dir == "." {
+		dir = ""
+	}
+
+	if cf.RootDir != nil {
+		config.RootDir = path.Join(dir, *cf.RootDir)
+		config.rootConfigured = true
+	}
+	if cf.Roots != nil {
+		config.Roots = nil
+		for _, root := range cf.Roots {
+			config.Roots = append(config.Roots, path.Join(dir, root))
+		}
+		config.rootConfigured = true
+	}
+	if cf.NamespacePackages != nil {
+		config.NamespacePackages = *cf.NamespacePackages
+	}
+	if cf.NameTemplate != nil {
+		config.NameTemplate = *cf.NameTemplate
+	}
+	var readInternalModuleList bool
+	if cf.InternalModuleListPath != nil {
+		listPath := path.Join(dir, *cf.InternalModuleListPath)
+		if listPath != config.InternalModuleListPath {
+			config.InternalModuleListPath = listPath
+			config.InternalModuleList, err = readInternalModuleListPath(filepath.Join(repoRoot, listPath))
+			if err != nil {
+				return err
+			}
+			readInternalModuleList = true
+		}
+	}
+	if cf.PythonVersion != nil {
+		if err := config.setPythonVersion(*cf.PythonVersion); err != nil {
+			return err
+		}
+	}
+	// Modules from configuration files are kept apart, to be added again
+	// whenever the list or the map is read.
+	if len(cf.InternalModules) > 0 {
+		internalModules := make(map[string]struct{})
+		for module := range config.ConfigInternalModules {
+			internalModules[module] = struct{}{}
+		}
+		for _, module := range cf.InternalModules {
+			internalModules[module] = struct{}{}
+		}
+		config.ConfigInternalModules = internalModules
+	}
+	if readInternalModuleList || len(cf.InternalModules) > 0 {
+		config.addConfigInternalModules()
+	}
+	// The external module map is read again if any of the inputs for it change.
+	var readExternalModuleMap bool
+	if cf.ExternalRepoNamePrefix != nil && *cf.ExternalRepoNamePrefix != config.ExternalRepoNamePrefix {
+		config.ExternalRepoNamePrefix = *cf.ExternalRepoNamePrefix
+		readExternalModuleMap = true
+	}
+	if cf.ExternalLabelTemplate != nil && *cf.ExternalLabelTemplate != config.ExternalLabelTemplate {
+		config.ExternalLabelTemplate = *cf.ExternalLabelTemplate
+		readExternalModuleMap = true
+	}
+	if cf.ModuleLabelTemplate != nil && *cf.ModuleLabelTemplate != config.ExternalModuleLabelTemplate {
+		config.ExternalModuleLabelTemplate = *cf.ModuleLabelTemplate
+		readExternalModuleMap = true
+	}
+	if cf.DistNameStyle != nil && internal.DistNameStyle(*cf.DistNameStyle) != config.DistNameStyle {
+		config.DistNameStyle = internal.DistNameStyle(*cf.DistNameStyle)
+		readExternalModuleMap = true
+	}
+	if cf.RequirementsPath != nil {
+		if requirementsPath := path.Join(dir, *cf.RequirementsPath); requirementsPath != config.RequirementsPath {
+			config.RequirementsPath = requirementsPath
+			readExternalModuleMap = true
+		}
+	}
+	if cf.ExternalModuleMapPath != nil {
+		if mapPath := path.Join(dir, *cf.ExternalModuleMapPath); mapPath != config.ExternalModuleMapPath {
+			config.ExternalModuleMapPath = mapPath
+			readExternalModuleMap = true
+		}
+	}
+	if cf.ExternalModuleMapName != nil {
+		config.ExternalModuleMapName = *cf.ExternalModuleMapName
+	}
+	if len(cf.ExternalModules) > 0 {
+		externalModules := make(map[string]string)
+		for imp, dist := range config.ConfigExternalModules {
+			externalModules[imp] = dist
+		}
+		for imp, dist := range cf.ExternalModules {
+			externalModules[imp] = dist
+		}
+		config.ConfigExternalModules = externalModules
+	}
+	if readExternalModuleMap && config.ExternalModuleMapPath != "" {
+		if err := config.readExternalModuleMap(repoRoot); err != nil {
+			return err
+		}
+	}
+	if readExternalModuleMap || len(cf.ExternalModules) > 0 {
+		config.addConfigExternalModules()
+	}
+	if cf.RequirementLoad != nil {
+		config.RequirementLoad = *cf.RequirementLoad
+	}
+	if cf.Strict != nil {
+		config.Strict = *cf.Strict
+	}
+	if cf.OptionalImports != nil {
+		config.OptionalImports = OptionalImportPolicy(*cf.OptionalImports)
+	}
+	if cf.TypeOnlyDepsAttr != nil {
+		config.TypeOnlyDepsAttr = *cf.TypeOnlyDepsAttr
+	}
+	if len(cf.DistributionLabels) > 0 {
+		distLabels := make(map[string]string)
+		for dist, target := range config.DistributionLabels {
+			distLabels[dist] = target
+		}
+		for dist, target := range cf.DistributionLabels {
+			distLabels[internal.NormalizeDistName(dist, internal.DistNameDash)] = target
+		}
+		config.DistributionLabels = distLabels
+	}
+	if len(cf.ExternalModuleProviders) > 0 {
+		providers := make(map[string]string)
+		for imp, dist := range config.ExternalModuleProviders {
+			providers[imp] = dist
+		}
+		for imp, dist := range cf.ExternalModuleProviders {
+			providers[imp] = internal.NormalizeDistName(dist, internal.DistNameDash)
+		}
+		config.ExternalModuleProviders = providers
+	}
+	if len(cf.ConditionSettings) > 0 {
+		settings := make(map[string]string)
+		for cond, setting := range config.ConditionSettings {
+			settings[cond] = setting
+		}
+		for cond, setting := range cf.ConditionSettings {
+			cond, setting, _ := parseConditionSetting(cond, setting, dir)
+			settings[cond] = setting
+		}
+		config.ConditionSettings = settings
+	}
+	if cf.TestPatterns != nil {
+		config.TestPatterns = cf.TestPatterns
+	}
+	return nil
+}
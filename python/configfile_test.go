@@ -0,0 +1,152 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package python
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	content := `version: 1
+root_dir: src
+name_template: "py_{module_name}"
+internal_modules: [sitecustomize]
+external_modules:
+  yaml: PyYAML
+distribution_labels:
+  PyYAML: "//third_party/pyyaml"
+test_patterns: ["*_test"]
+`
+	got, err := readConfigFile(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rootDir, nameTemplate := "src", "py_{module_name}"
+	want := &configFile{
+		Version:            1,
+		RootDir:            &rootDir,
+		NameTemplate:       &nameTemplate,
+		InternalModules:    []string{"sitecustomize"},
+		ExternalModules:    map[string]string{"yaml": "PyYAML"},
+		DistributionLabels: map[string]string{"PyYAML": "//third_party/pyyaml"},
+		TestPatterns:       []string{"*_test"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("(-got, +want):%s", diff)
+	}
+}
+
+func TestReadConfigFileErrors(t *testing.T) {
+	testCases := []struct {
+		content string
+		wantErr string
+	}{
+		{"", "missing version"},
+		{"root_dir: src", "line 1: missing version"},
+		{"version: 2", "line 1: unsupported version 2"},
+		{"version: 1\nroot: src", "line 2: field root not found"},
+		{"version: 1\nroot_dir: [src]", "line 2: cannot unmarshal"},
+		{"version: 1\nroot_dir: ../src", "line 2: root_dir \"../src\" must be within"},
+		{"version: 1\nroots: [src, ../lib]", "line 2: root \"../lib\" must be within"},
+		{"version: 1\nname_template: foo", "line 2: name_template \"foo\" must contain"},
+		{"version: 1\ndistribution_labels:\n  a: //a\n  b: //b:c:d", "line 4: invalid label for distribution \"b\""},
+		{"version: 1\ntest_patterns: ['[']", "line 2: invalid test pattern"},
+		{"version: 1\nexternal_label_template: '@{hub}//a:b:c'", "line 2: invalid label"},
+		{"version: 1\nrequirement_load: '@pip//:a:b'", "line 2: invalid label"},
+		{"version: 1\ndist_name_style: camel", "line 2: unknown distribution name style"},
+		{"version: 1\noptional_imports: never", "line 2: unknown optional import policy"},
+		{"version: 1\ntype_only_deps_attr: deps", "line 2: attribute \"deps\" is already set"},
+		{"version: 1\ntype_only_deps_attr: pyi-deps", "line 2: invalid attribute name"},
+		{"version: 1\ncondition_settings:\n  sys.maxsize > 0: //a", "line 3: invalid condition setting"},
+		{"version: 1\npython_version: '2.7'", "line 2: unsupported Python version"},
+	}
+
+	for i, testCase := range testCases {
+		_, err := readConfigFile(strings.NewReader(testCase.content))
+		if err == nil || !strings.Contains(err.Error(), testCase.wantErr) {
+			t.Errorf("test %d: got error %v, want error containing %q", i, err, testCase.wantErr)
+		}
+	}
+}
+
+func TestApplyConfigFile(t *testing.T) {
+	repoRoot := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(repoRoot, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"python.yaml": `version: 1
+root_dir: src
+external_repo_name_prefix: pip_
+internal_modules: [os]
+external_modules:
+  yaml: PyYAML
+`,
+		"sub/python.yaml": `version: 1
+root_dir: .
+python_version: "3.8"
+internal_modules: [sys]
+external_modules:
+  requests: requests
+distribution_labels:
+  requests: "//third_party:requests"
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(repoRoot, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var config Configuration
+	if err := config.applyConfigFile(repoRoot, "python.yaml"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parent := config
+	if err := config.applyConfigFile(repoRoot, "sub/python.yaml"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if diff := cmp.Diff(parent.RootDir, "src"); diff != "" {
+		t.Errorf("parent RootDir: (-got, +want):%s", diff)
+	}
+	if diff := cmp.Diff(parent.InternalModuleList, map[string]struct{}{"os": {}}); diff != "" {
+		t.Errorf("parent InternalModuleList: (-got, +want):%s", diff)
+	}
+	if diff := cmp.Diff(config.RootDir, "sub"); diff != "" {
+		t.Errorf("RootDir: (-got, +want):%s", diff)
+	}
+	if diff := cmp.Diff(config.InternalModuleList, map[string]struct{}{"os": {}, "sys": {}}); diff != "" {
+		t.Errorf("InternalModuleList: (-got, +want):%s", diff)
+	}
+	if parent.isInternalModule("zoneinfo") || config.isInternalModule("zoneinfo") || !config.isInternalModule("dummy_threading") {
+		t.Errorf("unexpected standard library modules for Python version %q", config.PythonVersion)
+	}
+	wantExternalModuleMap := map[string]ExternalModule{
+		"yaml":     {Dist: "pyyaml", PkgPath: "yaml", BazelTarget: "@pip_pyyaml//:pkg", Type: "py"},
+		"requests": {Dist: "requests", PkgPath: "requests", BazelTarget: "@pip_requests//:pkg", Type: "py"},
+	}
+	if diff := cmp.Diff(config.ExternalModuleMap, wantExternalModuleMap); diff != "" {
+		t.Errorf("ExternalModuleMap: (-got, +want):%s", diff)
+	}
+	if diff := cmp.Diff(config.DistributionLabels, map[string]string{"requests": "//third_party:requests"}); diff != "" {
+		t.Errorf("DistributionLabels: (-got, +want):%s", diff)
+	}
+}
@@ -16,7 +16,10 @@ package python
 
 import (
 	"bufio"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -24,13 +27,17 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/rule"
 	"github.com/siddharthab/bazel-gazelle-python/internal"
-	yaml "gopkg.in/yaml.v2"
+	"github.com/siddharthab/bazel-gazelle-python/python/parser"
+	yamlv2 "gopkg.in/yaml.v2"
+	yaml "gopkg.in/yaml.v3"
 )
 
 const languageName = "py"
@@ -39,21 +46,183 @@ const languageName = "py"
 const (
 	directiveExtension              = "py_extension"
 	directiveRoot                   = "py_root_dir"
+	directiveRoots                  = "py_roots"
+	directiveDetectRoots            = "py_detect_roots"
+	directiveNamespacePackages      = "py_namespace_packages"
 	directiveInternalModuleListPath = "py_internal_module_list_path"
+	directivePythonVersion          = "py_python_version"
 	directiveExternalModuleMapPath  = "py_external_module_map_path"
+	directiveExternalModuleMapName  = "py_external_module_map_name"
+	directiveRequirementsPath       = "py_requirements_path"
 	directiveExternalRepoNamePrefix = "py_external_repo_name_prefix"
+	directiveExternalLabelTemplate  = "py_external_label_template"
+	directiveModuleLabelTemplate    = "py_external_module_label_template"
+	directiveDistNameStyle          = "py_dist_name_style"
+	directiveExternalModuleProvider = "py_external_module_provider"
+	directiveRequirementLoad        = "py_requirement_load"
 	directiveNameTemplate           = "py_name_template"
+	directiveDeps                   = "py_deps"
+	directiveStrict                 = "py_strict"
+	directiveOptionalImports        = "py_optional_imports"
+	directiveTypeOnlyDepsAttr       = "py_type_only_deps_attr"
+	directiveConditionSetting       = "py_condition_setting"
+	directiveParseCacheDir          = "py_parse_cache_dir"
+	directiveConfig                 = "py_config"
 )
 
-var directiveKeys = []string{directiveExtension, directiveRoot, directiveInternalModuleListPath, directiveExternalModuleMapPath, directiveExternalRepoNamePrefix, directiveNameTemplate}
+var directiveKeys = []string{directiveExtension, directiveRoot, directiveRoots, directiveDetectRoots, directiveNamespacePackages, directiveInternalModuleListPath, directivePythonVersion, directiveExternalModuleMapPath, directiveExternalModuleMapName, directiveRequirementsPath, directiveExternalRepoNamePrefix, directiveExternalLabelTemplate, directiveModuleLabelTemplate, directiveDistNameStyle, directiveExternalModuleProvider, directiveRequirementLoad, directiveNameTemplate, directiveDeps, directiveStrict, directiveOptionalImports, directiveTypeOnlyDepsAttr, directiveConditionSetting, directiveParseCacheDir, directiveConfig}
+
+// Label template for external modules, matching the repositories created by
+// pip_parse in a WORKSPACE, unless configured otherwise.
+const defaultExternalLabelTemplate = "@{prefix}{normalized_dist}//:pkg"
+
+// Style of distribution names in labels, matching the repositories created by
+// pip_parse, unless configured otherwise.
+const defaultDistNameStyle = internal.DistNameUnderscore
+
+// Python version for the list of standard library modules, unless configured
+// otherwise.
+const defaultPythonVersion = "3.13"
+
+// Module name patterns for test modules, unless configured otherwise.
+var defaultTestPatterns = []string{"__test__", "test_*", "*_test"}
 
 // ExternalModule is a Python module available from an external distribution.
 type ExternalModule struct {
-	Dist        string // Distribution name.
+	Dist        string // Distribution name, normalized as per PEP 503.
 	PkgPath     string // A slash separated path to the package.
 	Module      string // Name of the module, can be blank for __init__.py (but not when PkgPath is also blank).
 	BazelTarget string // Bazel target for this import.
-	Type        string // py or so (currently not relevant).
+	Type        string // py or so.
+	// Other distributions which also provide the module, sorted by
+	// distribution name. The module is then either a namespace package shared
+	// by the distributions, or in conflict between them.
+	OtherProviders []ExternalModule
+	// Whether the module is a namespace package to which each of the
+	// providers adds its own modules, e.g. "google". Such packages are not
+	// resolved to any one distribution.
+	Namespace bool
+}
+
+// Returns the provider of the module from the given distribution.
+func (module ExternalModule) provider(dist string) (ExternalModule, bool) {
+	if module.Dist == dist {
+		return module, true
+	}
+	for _, other := range module.OtherProviders {
+		if other.Dist == dist {
+			return other, true
+		}
+	}
+	return ExternalModule{}, false
+}
+
+// Returns the names of the distributions which provide the module.
+func (module ExternalModule) dists() []string {
+	res := []string{module.Dist}
+	for _, other := range module.OtherProviders {
+		res = append(res, other.Dist)
+	}
+	return res
+}
+
+// labelTemplate gives the Bazel labels for modules from external
+// distributions.
+type labelTemplate struct {
+	// Template with the placeholders:
+	//
+	//   - {prefix}: the external repo name prefix, e.g. "pip_".
+	//   - {hub}: the name of the hub repository, i.e. the prefix without a
+	//     trailing "_", e.g. "pip".
+	//   - {dist}: the distribution name normalized as per PEP 503, e.g.
+	//     "ruamel-yaml".
+	//   - {normalized_dist}: the distribution name normalized in
+	//     DistNameStyle, e.g. "ruamel_yaml".
+	//   - {module}: the import specifier of the module, e.g. "ruamel.yaml.main".
+	//
+	// For example, "@{hub}//{normalized_dist}" gives labels for a bzlmod pip
+	// hub. If empty, defaultExternalLabelTemplate is used.
+	Template string
+	// External repo name prefix.
+	NamePrefix string
+	// Style of distribution names for {normalized_dist}. If empty,
+	// defaultDistNameStyle is used.
+	DistNameStyle internal.DistNameStyle
+}
+
+// Returns the label for a module, given by the import specifier, from the
+// distribution.
+func (t labelTemplate) label(dist, importSpec string) string {
+	template, style := t.Template, t.DistNameStyle
+	if template == "" {
+		template = defaultExternalLabelTemplate
+	}
+	if style == "" {
+		style = defaultDistNameStyle
+	}
+	dist = internal.NormalizeDistName(dist, internal.DistNameDash)
+	return strings.NewReplacer(
+		"{prefix}", t.NamePrefix,
+		"{hub}", strings.TrimSuffix(t.NamePrefix, "_"),
+		"{dist}", dist,
+		"{normalized_dist}", internal.NormalizeDistName(dist, style),
+		"{module}", importSpec,
+	).Replace(template)
+}
+
+// Checks that the template gives valid labels, with a sample name prefix as
+// the prefix may yet be set from an external module map.
+func (t labelTemplate) validate() error {
+	t.NamePrefix = "pip_"
+	target := t.label("dist", "pkg.module")
+	if _, err := label.Parse(target); err != nil {
+		return fmt.Errorf("invalid label %q from template %q: %w", target, t.Template, err)
+	}
+	return nil
+}
+
+// Returns the external module for an import specifier from a distribution, when
+// it is not known whether the import specifier is for a package or a module;
+// it is then treated as a package.
+func newExternalModule(importSpec, dist string, labels labelTemplate) ExternalModule {
+	return ExternalModule{
+		Dist:        internal.NormalizeDistName(dist, internal.DistNameDash),
+		PkgPath:     strings.ReplaceAll(importSpec, ".", "/"),
+		BazelTarget: labels.label(dist, importSpec),
+		Type:        "py",
+	}
+}
+
+// RuleDeps are dependencies to add to or remove from a generated rule after
+// resolution, as given by directives like
+// `# gazelle:py_deps <rule> +//foo:bar -@pip_numpy//:pkg`. Relative labels are
+// relative to the package of the rule.
+type RuleDeps struct {
+	Add    []label.Label
+	Remove []label.Label
+}
+
+// OptionalImportPolicy is how optional imports, i.e. those in the body of a try
+// statement which handles ImportError, are resolved.
+type OptionalImportPolicy string
+
+const (
+	// Depend on the module if it can be resolved; otherwise the import is
+	// ignored without being logged or reported.
+	OptionalImportsIfResolvable OptionalImportPolicy = "if_resolvable"
+	// Never depend on the module, unless it is in the same package.
+	OptionalImportsDrop OptionalImportPolicy = "drop"
+	// Resolve the import as any other.
+	OptionalImportsInclude OptionalImportPolicy = "include"
+)
+
+// Returns the policy with the given name.
+func parseOptionalImportPolicy(name string) (OptionalImportPolicy, error) {
+	switch policy := OptionalImportPolicy(name); policy {
+	case OptionalImportsIfResolvable, OptionalImportsDrop, OptionalImportsInclude:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown optional import policy %q; must be one of %q, %q or %q", name, OptionalImportsIfResolvable, OptionalImportsDrop, OptionalImportsInclude)
 }
 
 // Configuration is configuration for the Python language extension. A default
@@ -65,10 +234,25 @@ type Configuration struct {
 	Enable bool
 	// Root directory for Python code.
 	RootDir string
+	// Root directories for Python code (slash separated, relative to the
+	// repository root), in decreasing order of priority, as in sys.path. Each
+	// directory is in the deepest of these roots and RootDir which contains it.
+	// Modules in roots of higher priority shadow those of the same name in
+	// other roots; RootDir, if not one of these, has the lowest priority.
+	Roots []string
+	// Detect the roots of Python projects from their packaging configuration
+	// in pyproject.toml or setup.cfg, or a src layout; see detectRoots.
+	DetectRoots bool
+	// Whether RootDir or Roots was set by a flag, directive or configuration
+	// file, here or in a parent directory; detected roots never replace them.
+	rootConfigured bool
 	// Python package path (slash separated); its value is Bazel package path
 	// relative to RootDir. If this starts with '..', then it means we have not
 	// yet reached the Python workspace.
 	PythonPackagePath string
+	// Treat directories without __init__.py as implicit namespace packages
+	// (PEP 420), which may be split across Python roots.
+	NamespacePackages bool
 	// List of modules internal to the interpreter, whether part of stdlib, or
 	// available as a system installation. A common default for such a list
 	// would be given by
@@ -77,21 +261,103 @@ type Configuration struct {
 	// Path to list (one per line; comment char '#') of internal modules.
 	// Functions as a caching key for InternalModuleList.
 	InternalModuleListPath string
+	// Internal modules from configuration files, which are added to
+	// InternalModuleList whenever it is read.
+	ConfigInternalModules map[string]struct{}
+	// Python version, e.g. "3.11", for selecting the list of standard library
+	// modules embedded in the extension. If empty, no standard library modules
+	// are assumed besides those in InternalModuleList.
+	PythonVersion string
+	// Top-level modules in the standard library of PythonVersion, which are
+	// also internal to the interpreter.
+	StdlibModuleList map[string]struct{}
 	// Map of import specifiers for external module names to their sources.
 	ExternalModuleMap map[string]ExternalModule
+	// External modules from configuration files, from import specifier to
+	// distribution name, which are added to ExternalModuleMap whenever it is
+	// read or its labels change, with labels from the current template.
+	ConfigExternalModules map[string]string
 	// Path to map of external modules from where ExternalModuleMap is
 	// read. Functions as a caching key for ExternalModuleMap.
 	ExternalModuleMapPath string
+	// File name of the external module maps next to a requirements.txt or
+	// pyproject.toml, which bind the directory and its subdirectories to the
+	// map and the requirements file. If empty, maps are only set explicitly.
+	ExternalModuleMapName string
+	// External module maps which have been read, shared by all directories.
+	externalModuleMaps externalModuleMapCache
+	// Path to the requirements file from which a YAML external module map was
+	// generated, used to verify the integrity hash in the map.
+	RequirementsPath string
 	// Name prefix under which the external repositories are defined, e.g. "pip_".
+	// If empty, the name of the pip repository in a YAML external module map
+	// is used as the prefix, followed by "_".
 	ExternalRepoNamePrefix string
+	// Template for the Bazel labels of external modules; see labelTemplate.
+	ExternalLabelTemplate string
+	// Template for the Bazel labels of external modules which have rules of
+	// their own, i.e. those of distributions with a module dependency graph in
+	// a TSV external module map; see labelTemplate. If empty, the labels from
+	// ExternalLabelTemplate are used for all modules.
+	ExternalModuleLabelTemplate string
+	// Style of normalized distribution names in the labels of external
+	// modules.
+	DistNameStyle internal.DistNameStyle
+	// Label of the .bzl file from which to load the requirement() macro. If
+	// set, dependencies on external distributions are given as requirement()
+	// calls instead of labels.
+	RequirementLoad string
+	// Bazel targets for external distributions, keyed by distribution name
+	// normalized as per PEP 503, which override the targets in
+	// ExternalModuleMap.
+	DistributionLabels map[string]string
+	// Distributions, normalized as per PEP 503, chosen to provide the external
+	// modules which are in conflict between distributions, keyed by import
+	// specifier.
+	ExternalModuleProviders map[string]string
 	// Name template to use for naming targets.
 	NameTemplate string
+	// Patterns (as in path.Match) for names of modules which are tests.
+	TestPatterns []string
+	// Dependency overrides for generated rules, keyed by rule name. These only
+	// apply to the directory with the directives, and are not inherited.
+	RuleDeps map[string]RuleDeps
+	// Fail if any import can not be resolved to a Bazel rule.
+	Strict bool
+	// How optional imports, in the body of a try statement which handles
+	// ImportError, are resolved.
+	OptionalImports OptionalImportPolicy
+	// Attribute of generated rules for the dependencies only imported under
+	// `if TYPE_CHECKING:`, e.g. for the type checking rules of a macro. If
+	// empty, such imports are not dependencies.
+	TypeOnlyDepsAttr string
+	// Labels of the config_setting rules, or constraint values, which match
+	// the conditions on the Python version or the platform, keyed by the
+	// condition in the canonical form of parser.NormalizeCondition.
+	// Dependencies imported under these conditions are given in select()
+	// expressions, and others unconditionally.
+	ConditionSettings map[string]string
+	// Path to a report (.json or .tsv) of the imports which could not be
+	// resolved. Only set by flag.
+	UnresolvedReportPath string
+	// Maximum number of files to parse concurrently in a package; if not
+	// positive, then GOMAXPROCS. Only set by flag.
+	Parallelism int
+	// Directory, relative to the repository root, for caching the results of
+	// parsing Python files. Functions as a caching key for ParseCache.
+	ParseCacheDir string
+	// Cache for parse results; nil if not enabled.
+	ParseCache *parser.Cache
+	// Log details of the resolution of imports. Only set by flag.
+	Debug bool
 }
 
 // Configurer manages the configuration at root and for each subdirectory.
 type Configurer struct {
 	// Initial copy of the configuration, before it is copied as an extension configuration to Gazelle.
 	initial Configuration
+	// Path to the configuration file given by the flag.
+	configPath string
 }
 
 var _ config.Configurer = &Configurer{}
@@ -103,10 +369,28 @@ var _ config.Configurer = &Configurer{}
 func (pc *Configurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
 	fs.BoolVar(&pc.initial.Enable, "py-extension", true, "Enable Python language extension.")
 	fs.StringVar(&pc.initial.RootDir, "py-root-dir", "", "Root directory for Python code.")
+	fs.Var((*rootsFlag)(&pc.initial.Roots), "py-roots", "Comma separated root directories for Python code, in decreasing order of priority.")
+	fs.BoolVar(&pc.initial.DetectRoots, "py-detect-roots", false, "Detect the roots of Python projects from pyproject.toml, setup.cfg or a src layout, unless roots are set explicitly.")
 	fs.StringVar(&pc.initial.InternalModuleListPath, "py-internal-modules-path", "", "Path to manifest of external modules.")
+	fs.StringVar(&pc.initial.PythonVersion, "py-python-version", defaultPythonVersion, "Python version for the list of standard library modules, e.g. 3.11; empty for none.")
 	fs.StringVar(&pc.initial.ExternalModuleMapPath, "py-external-modules-path", "", "Path to manifest of external modules.")
+	fs.StringVar(&pc.initial.ExternalModuleMapName, "py-external-modules-name", "", "File name of the manifests of external modules next to a requirements.txt or pyproject.toml, which apply to the directory tree.")
+	fs.StringVar(&pc.initial.RequirementsPath, "py-requirements-path", "", "Path to requirements file for verifying the integrity of a YAML manifest of external modules.")
 	fs.StringVar(&pc.initial.ExternalRepoNamePrefix, "py-external-repo-name-prefix", "", "Name prefix under which the external repositories are defined.")
+	fs.StringVar(&pc.initial.ExternalLabelTemplate, "py-external-label-template", defaultExternalLabelTemplate, "Template for Bazel labels of external modules, with placeholders {prefix}, {hub}, {dist}, {normalized_dist} and {module}.")
+	fs.StringVar(&pc.initial.ExternalModuleLabelTemplate, "py-external-module-label-template", "", "Template for Bazel labels of the rules for individual external modules, for distributions with a module dependency graph in the manifest of external modules.")
+	fs.StringVar((*string)(&pc.initial.DistNameStyle), "py-dist-name-style", string(defaultDistNameStyle), "Style of normalized distribution names in labels of external modules; one of dash, underscore or lowercase.")
+	fs.StringVar(&pc.initial.RequirementLoad, "py-requirement-load", "", "Label of the .bzl file with the requirement() macro, to use for external dependencies instead of labels.")
 	fs.StringVar(&pc.initial.NameTemplate, "py-name-template", "{module_name}", "Name prefix under which the external repositories are defined.")
+	fs.BoolVar(&pc.initial.Strict, "py-strict", false, "Fail without writing BUILD files if any import can not be resolved.")
+	fs.StringVar((*string)(&pc.initial.OptionalImports), "py-optional-imports", string(OptionalImportsIfResolvable), "How imports in try statements which handle ImportError are resolved; one of if_resolvable, drop or include.")
+	fs.StringVar(&pc.initial.TypeOnlyDepsAttr, "py-type-only-deps-attr", "", "Attribute of generated rules for dependencies only imported for type checking; empty for none.")
+	fs.StringVar(&pc.initial.UnresolvedReportPath, "py-unresolved-report", "", "Path to write a report (.json or .tsv) of imports which could not be resolved.")
+	fs.IntVar(&pc.initial.Parallelism, "py-parallelism", 0, "Maximum number of Python files to parse concurrently in a package; GOMAXPROCS if not positive.")
+	fs.StringVar(&pc.initial.ParseCacheDir, "py-parse-cache-dir", "", "Directory, relative to the repository root, for caching the results of parsing Python files.")
+	fs.BoolVar(&pc.initial.Debug, "py-debug", false, "Log details of the resolution of imports.")
+	fs.StringVar(&pc.configPath, "py-config", "", "Path to the configuration file for the Python extension, applied after the other flags.")
+	pc.initial.TestPatterns = defaultTestPatterns
 }
 
 // CheckFlags implements config.Configurer.
@@ -117,15 +401,62 @@ func (pc *Configurer) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
 	var err error
 	var config Configuration
 	config, pc.initial = pc.initial, Configuration{} // Swap out the value in the configurer.
+	config.rootConfigured = config.RootDir != "" || len(config.Roots) > 0
+	if _, err := internal.ParseDistNameStyle(string(config.DistNameStyle)); err != nil {
+		return err
+	}
+	if _, err := parseOptionalImportPolicy(string(config.OptionalImports)); err != nil {
+		return err
+	}
+	if err := validateTypeOnlyDepsAttr(config.TypeOnlyDepsAttr); err != nil {
+		return err
+	}
+	if err := config.labelTemplate().validate(); err != nil {
+		return err
+	}
+	if config.ExternalModuleLabelTemplate != "" {
+		if err := config.moduleLabelTemplate().validate(); err != nil {
+			return err
+		}
+	}
+	if config.RequirementLoad != "" {
+		if _, err := label.Parse(config.RequirementLoad); err != nil {
+			return fmt.Errorf("invalid label for -py-requirement-load: %w", err)
+		}
+	}
+	if config.UnresolvedReportPath != "" {
+		if err := validateUnresolvedReportPath(config.UnresolvedReportPath); err != nil {
+			return err
+		}
+		if !filepath.IsAbs(config.UnresolvedReportPath) {
+			config.UnresolvedReportPath = filepath.Join(c.RepoRoot, config.UnresolvedReportPath)
+		}
+		// Write an empty report, so that a stale report is not left behind if
+		// there are no rules to resolve.
+		if err := writeUnresolvedReportPath(config.UnresolvedReportPath, nil); err != nil {
+			return err
+		}
+	}
+	if err := config.setPythonVersion(config.PythonVersion); err != nil {
+		return err
+	}
+	if err := config.setParseCacheDir(c.RepoRoot, config.ParseCacheDir); err != nil {
+		return err
+	}
 	if config.InternalModuleListPath != "" {
 		config.InternalModuleList, err = readInternalModuleListPath(filepath.Join(c.RepoRoot, config.InternalModuleListPath))
 		if err != nil {
 			return err
 		}
 	}
+	config.externalModuleMaps = make(externalModuleMapCache)
 	if config.ExternalModuleMapPath != "" {
-		config.ExternalModuleMap, err = readExternalModuleMapPath(filepath.Join(c.RepoRoot, config.ExternalModuleMapPath), pc.initial.ExternalRepoNamePrefix)
-		if err != nil {
+		if err := config.readExternalModuleMap(c.RepoRoot); err != nil {
+			return err
+		}
+	}
+	if pc.configPath != "" {
+		if err := config.applyConfigFile(c.RepoRoot, pc.configPath); err != nil {
 			return err
 		}
 	}
@@ -147,7 +478,21 @@ func (pc Configurer) Configure(c *config.Config, rel string, f *rule.File) {
 	}
 
 	var err error
-	var readInternalModuleList, readExternalModuleMap bool
+	var readInternalModuleList, readExternalModuleMap, externalModuleMapChanged bool
+	config.RuleDeps = nil
+	// Bind this directory to the external module map next to its lock file,
+	// before any directives which may set the map explicitly.
+	if config.ExternalModuleMapName != "" {
+		if mapPath, requirementsPath, ok := findExternalModuleMap(c.RepoRoot, rel, config.ExternalModuleMapName); ok {
+			if config.ExternalModuleMapPath != mapPath {
+				readExternalModuleMap = true
+			}
+			if config.RequirementsPath != requirementsPath {
+				externalModuleMapChanged = true
+			}
+			config.ExternalModuleMapPath, config.RequirementsPath = mapPath, requirementsPath
+		}
+	}
 	for _, d := range directives {
 		switch d.Key {
 		case directiveExtension:
@@ -157,42 +502,190 @@ func (pc Configurer) Configure(c *config.Config, rel string, f *rule.File) {
 			}
 		case directiveRoot:
 			config.RootDir = path.Join(rel, d.Value)
+			config.rootConfigured = true
+		case directiveDetectRoots:
+			config.DetectRoots, err = strconv.ParseBool(d.Value)
+			if err != nil {
+				log.Fatalf("invalid directive value %q for %q in %q: %v", d.Value, d.Key, rel, err)
+			}
+		case directiveRoots:
+			config.Roots = nil
+			for _, root := range strings.Fields(d.Value) {
+				config.Roots = append(config.Roots, path.Join(rel, root))
+			}
+			config.rootConfigured = true
+		case directiveNamespacePackages:
+			config.NamespacePackages, err = strconv.ParseBool(d.Value)
+			if err != nil {
+				log.Fatalf("invalid directive value %q for %q in %q: %v", d.Value, d.Key, rel, err)
+			}
 		case directiveInternalModuleListPath:
 			if config.InternalModuleListPath != d.Value {
-				if config.InternalModuleListPath != "" {
-					readInternalModuleList = true
-				} else {
-					config.InternalModuleList = nil
-				}
+				readInternalModuleList = true
 			}
 			config.InternalModuleListPath = d.Value
+		case directivePythonVersion:
+			if err := config.setPythonVersion(d.Value); err != nil {
+				log.Fatalf("invalid directive value %q for %q in %q: %v", d.Value, d.Key, rel, err)
+			}
 		case directiveExternalModuleMapPath:
 			if config.ExternalModuleMapPath != d.Value {
-				if config.ExternalModuleMapPath != "" {
-					readExternalModuleMap = true
-				} else {
-					config.ExternalModuleMap = make(map[string]ExternalModule)
-				}
+				readExternalModuleMap = true
 			}
 			config.ExternalModuleMapPath = d.Value
+		case directiveExternalModuleMapName:
+			config.ExternalModuleMapName = d.Value
+		case directiveRequirementsPath:
+			if config.RequirementsPath != d.Value {
+				externalModuleMapChanged = true
+			}
+			config.RequirementsPath = d.Value
 		case directiveExternalRepoNamePrefix:
+			if config.ExternalRepoNamePrefix != d.Value {
+				externalModuleMapChanged = true
+			}
 			config.ExternalRepoNamePrefix = d.Value
+		case directiveExternalLabelTemplate:
+			if err := (labelTemplate{Template: d.Value}).validate(); err != nil {
+				log.Fatalf("invalid directive value %q for %q in %q: %v", d.Value, d.Key, rel, err)
+			}
+			if config.ExternalLabelTemplate != d.Value {
+				externalModuleMapChanged = true
+			}
+			config.ExternalLabelTemplate = d.Value
+		case directiveModuleLabelTemplate:
+			if d.Value != "" {
+				if err := (labelTemplate{Template: d.Value}).validate(); err != nil {
+					log.Fatalf("invalid directive value %q for %q in %q: %v", d.Value, d.Key, rel, err)
+				}
+			}
+			if config.ExternalModuleLabelTemplate != d.Value {
+				externalModuleMapChanged = true
+			}
+			config.ExternalModuleLabelTemplate = d.Value
+		case directiveDistNameStyle:
+			style, err := internal.ParseDistNameStyle(d.Value)
+			if err != nil {
+				log.Fatalf("invalid directive value %q for %q in %q: %v", d.Value, d.Key, rel, err)
+			}
+			if config.DistNameStyle != style {
+				externalModuleMapChanged = true
+			}
+			config.DistNameStyle = style
+		case directiveRequirementLoad:
+			if d.Value != "" {
+				if _, err := label.Parse(d.Value); err != nil {
+					log.Fatalf("invalid directive value %q for %q in %q: %v", d.Value, d.Key, rel, err)
+				}
+			}
+			config.RequirementLoad = d.Value
 		case directiveNameTemplate:
 			config.NameTemplate = d.Value
+		case directiveParseCacheDir:
+			if config.ParseCacheDir != d.Value {
+				if err := config.setParseCacheDir(c.RepoRoot, d.Value); err != nil {
+					log.Fatal(err)
+				}
+			}
+		case directiveStrict:
+			config.Strict, err = strconv.ParseBool(d.Value)
+			if err != nil {
+				log.Fatalf("invalid directive value %q for %q in %q: %v", d.Value, d.Key, rel, err)
+			}
+		case directiveOptionalImports:
+			config.OptionalImports, err = parseOptionalImportPolicy(d.Value)
+			if err != nil {
+				log.Fatalf("invalid directive value %q for %q in %q: %v", d.Value, d.Key, rel, err)
+			}
+		case directiveTypeOnlyDepsAttr:
+			if err := validateTypeOnlyDepsAttr(d.Value); err != nil {
+				log.Fatalf("invalid directive value %q for %q in %q: %v", d.Value, d.Key, rel, err)
+			}
+			config.TypeOnlyDepsAttr = d.Value
+		case directiveConditionSetting:
+			fields := strings.Fields(d.Value)
+			if len(fields) < 2 {
+				log.Fatalf("invalid directive value %q for %q in %q: expected a condition and a label", d.Value, d.Key, rel)
+			}
+			target := fields[len(fields)-1]
+			cond, setting, err := parseConditionSetting(strings.TrimSuffix(d.Value, target), target, rel)
+			if err != nil {
+				log.Fatalf("invalid directive value %q for %q in %q: %v", d.Value, d.Key, rel, err)
+			}
+			settings := make(map[string]string)
+			for k, v := range config.ConditionSettings {
+				settings[k] = v
+			}
+			settings[cond] = setting
+			config.ConditionSettings = settings
+		case directiveConfig:
+			if err := config.applyConfigFile(c.RepoRoot, d.Value); err != nil {
+				log.Fatal(err)
+			}
+		case directiveExternalModuleProvider:
+			imp, dist, err := parseExternalModuleProvider(d.Value)
+			if err != nil {
+				log.Fatalf("invalid directive value %q for %q in %q: %v", d.Value, d.Key, rel, err)
+			}
+			providers := make(map[string]string)
+			for k, v := range config.ExternalModuleProviders {
+				providers[k] = v
+			}
+			providers[imp] = dist
+			config.ExternalModuleProviders = providers
+		case directiveDeps:
+			name, deps, err := parseRuleDeps(d.Value)
+			if err != nil {
+				log.Fatalf("invalid directive value %q for %q in %q: %v", d.Value, d.Key, rel, err)
+			}
+			if config.RuleDeps == nil {
+				config.RuleDeps = make(map[string]RuleDeps)
+			}
+			prev := config.RuleDeps[name]
+			config.RuleDeps[name] = RuleDeps{
+				Add:    append(prev.Add, deps.Add...),
+				Remove: append(prev.Remove, deps.Remove...),
+			}
 		}
 	}
 	if readInternalModuleList {
-		config.InternalModuleList, err = readInternalModuleListPath(filepath.Join(c.RepoRoot, config.InternalModuleListPath))
-		if err != nil {
-			log.Fatal(err)
+		config.InternalModuleList = nil
+		if config.InternalModuleListPath != "" {
+			config.InternalModuleList, err = readInternalModuleListPath(filepath.Join(c.RepoRoot, config.InternalModuleListPath))
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		config.addConfigInternalModules()
+	}
+	// The map is also read again when the repo name prefix, label template,
+	// distribution name style or the requirements file changes, as these are
+	// part of the records in the map, and so are the modules from
+	// configuration files.
+	if readExternalModuleMap || externalModuleMapChanged {
+		if readExternalModuleMap || config.ExternalModuleMapPath != "" {
+			config.ExternalModuleMap = nil
+			if config.ExternalModuleMapPath != "" {
+				if err := config.readExternalModuleMap(c.RepoRoot); err != nil {
+					log.Fatal(err)
+				}
+			}
 		}
+		config.addConfigExternalModules()
 	}
-	if readExternalModuleMap {
-		config.ExternalModuleMap, err = readExternalModuleMapPath(filepath.Join(c.RepoRoot, config.ExternalModuleMapPath), config.ExternalRepoNamePrefix)
+	if config.DetectRoots && !config.rootConfigured {
+		roots, err := detectRoots(c.RepoRoot, rel)
 		if err != nil {
-			log.Fatal(err)
+			log.Print(err)
+		}
+		if len(roots) > 0 {
+			config.RootDir = roots[0]
+		}
+		if len(roots) > 1 {
+			config.Roots = append(config.Roots[:len(config.Roots):len(config.Roots)], roots...)
 		}
 	}
+	config.RootDir = config.rootDirFor(rel)
 	// Compute the Python package path for this directory.
 	rootRel, err := filepath.Rel(filepath.FromSlash(config.RootDir), filepath.FromSlash(rel))
 	if err != nil {
@@ -206,6 +699,191 @@ func (pc Configurer) Configure(c *config.Config, rel string, f *rule.File) {
 	c.Exts[languageName] = config
 }
 
+// rootsFlag is a flag for comma separated root directories.
+type rootsFlag []string
+
+func (f *rootsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *rootsFlag) Set(value string) error {
+	*f = nil
+	for _, root := range strings.Split(value, ",") {
+		if root = strings.TrimSpace(root); root != "" {
+			*f = append(*f, path.Clean(root))
+		}
+	}
+	return nil
+}
+
+// Returns true if the directory is the root or within it; "" and "." are the
+// repository root.
+func rootContains(root, dir string) bool {
+	if root == "" || root == "." {
+		return true
+	}
+	return dir == root || strings.HasPrefix(dir, root+"/")
+}
+
+// Returns the deepest of Roots and RootDir which contains the directory.
+func (config *Configuration) rootDirFor(dir string) string {
+	res := config.RootDir
+	for _, root := range config.Roots {
+		if rootContains(root, dir) && (!rootContains(res, dir) || len(root) > len(res)) {
+			res = root
+		}
+	}
+	return res
+}
+
+// Returns the priority of RootDir as a position in Roots; lower values have
+// higher priority.
+func (config *Configuration) rootPriority() int {
+	for i, root := range config.Roots {
+		if path.Clean(root) == path.Clean(config.RootDir) {
+			return i
+		}
+	}
+	return len(config.Roots)
+}
+
+// Sets the Python version and the corresponding list of standard library
+// modules.
+func (config *Configuration) setPythonVersion(version string) error {
+	config.PythonVersion = version
+	config.StdlibModuleList = nil
+	if version == "" {
+		return nil
+	}
+	var err error
+	config.StdlibModuleList, err = internal.StdlibModules(version)
+	return err
+}
+
+// Sets the directory for the parse cache, and opens the cache in it.
+func (config *Configuration) setParseCacheDir(repoRoot, dir string) error {
+	config.ParseCacheDir = dir
+	config.ParseCache = nil
+	if dir == "" {
+		return nil
+	}
+	var err error
+	config.ParseCache, err = parser.NewCache(filepath.Join(repoRoot, dir))
+	return err
+}
+
+// Adds the internal modules from configuration files to InternalModuleList,
+// which may be shared with other directories and is copied.
+func (config *Configuration) addConfigInternalModules() {
+	if len(config.ConfigInternalModules) == 0 {
+		return
+	}
+	internalModules := make(map[string]struct{}, len(config.InternalModuleList)+len(config.ConfigInternalModules))
+	for module := range config.InternalModuleList {
+		internalModules[module] = struct{}{}
+	}
+	for module := range config.ConfigInternalModules {
+		internalModules[module] = struct{}{}
+	}
+	config.InternalModuleList = internalModules
+}
+
+// Adds the external modules from configuration files to ExternalModuleMap,
+// which may be shared with other directories and is copied, with labels from
+// the current label template.
+func (config *Configuration) addConfigExternalModules() {
+	if len(config.ConfigExternalModules) == 0 {
+		return
+	}
+	externalModules := make(map[string]ExternalModule, len(config.ExternalModuleMap)+len(config.ConfigExternalModules))
+	for imp, module := range config.ExternalModuleMap {
+		externalModules[imp] = module
+	}
+	for imp, dist := range config.ConfigExternalModules {
+		externalModules[imp] = newExternalModule(imp, dist, config.labelTemplate())
+	}
+	config.ExternalModuleMap = externalModules
+}
+
+// Returns true if the import specifier is for a module internal to the
+// interpreter, either from the standard library or the list of internal
+// modules.
+func (config *Configuration) isInternalModule(imp string) bool {
+	if _, ok := config.StdlibModuleList[imp]; ok {
+		return true
+	}
+	_, ok := config.InternalModuleList[imp]
+	return ok
+}
+
+// Checks that the attribute for type-only dependencies is empty, or a valid
+// attribute name other than those set when generating rules.
+func validateTypeOnlyDepsAttr(name string) error {
+	if name == "" {
+		return nil
+	}
+	for i, c := range name {
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9') {
+			return fmt.Errorf("invalid attribute name %q", name)
+		}
+	}
+	switch name {
+	case "deps", "srcs", "imports", "main", "tags", "visibility":
+		return fmt.Errorf("attribute %q is already set on generated rules", name)
+	}
+	return nil
+}
+
+// Returns the canonical form of the condition, and the absolute label of the
+// setting for it, with relative labels relative to the Bazel package pkg.
+func parseConditionSetting(cond, setting, pkg string) (string, string, error) {
+	cond, err := parser.NormalizeCondition(cond)
+	if err != nil {
+		return "", "", err
+	}
+	l, err := label.Parse(setting)
+	if err != nil {
+		return "", "", err
+	}
+	return cond, l.Abs("", pkg).String(), nil
+}
+
+// Parses the value of a py_external_module_provider directive, given as the
+// import specifier followed by the distribution name.
+func parseExternalModuleProvider(value string) (string, string, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("expected an import specifier and a distribution name")
+	}
+	return fields[0], internal.NormalizeDistName(fields[1], internal.DistNameDash), nil
+}
+
+// Parses the value of a py_deps directive, given as the rule name followed by
+// labels prefixed with '+' to add, or '-' to remove.
+func parseRuleDeps(value string) (string, RuleDeps, error) {
+	var deps RuleDeps
+	fields := strings.Fields(value)
+	if len(fields) < 2 {
+		return "", deps, fmt.Errorf("expected a rule name and at least one label")
+	}
+	for _, field := range fields[1:] {
+		op, value := field[0], field[1:]
+		if op != '+' && op != '-' {
+			return "", deps, fmt.Errorf("label %q must be prefixed with '+' or '-'", field)
+		}
+		l, err := label.Parse(value)
+		if err != nil {
+			return "", deps, err
+		}
+		if op == '+' {
+			deps.Add = append(deps.Add, l)
+		} else {
+			deps.Remove = append(deps.Remove, l)
+		}
+	}
+	return fields[0], deps, nil
+}
+
 func readInternalModuleListPath(path string) (map[string]struct{}, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -233,77 +911,230 @@ func readInternalModuleList(r io.Reader) (map[string]struct{}, error) {
 	return res, scanner.Err()
 }
 
-func readExternalModuleMapPath(path, namePrefix string) (map[string]ExternalModule, error) {
-	f, err := os.Open(path)
+// Returns the label template for external modules.
+func (config *Configuration) labelTemplate() labelTemplate {
+	return labelTemplate{
+		Template:      config.ExternalLabelTemplate,
+		NamePrefix:    config.ExternalRepoNamePrefix,
+		DistNameStyle: config.DistNameStyle,
+	}
+}
+
+// Returns the label template for external modules with rules of their own,
+// with an empty template if there are none.
+func (config *Configuration) moduleLabelTemplate() labelTemplate {
+	labels := config.labelTemplate()
+	labels.Template = config.ExternalModuleLabelTemplate
+	return labels
+}
+
+// Reads the external module map at ExternalModuleMapPath, relative to the
+// repository root, in TSV format, or in the YAML format of the manifest
+// generated for the Gazelle extension in rules_python. The integrity of a YAML
+// manifest is verified if RequirementsPath is set.
+func (config *Configuration) readExternalModuleMap(repoRoot string) error {
+	mapPath := config.ExternalModuleMapPath
+	key := externalModuleMapKey{
+		mapPath:          mapPath,
+		requirementsPath: config.RequirementsPath,
+		labels:           config.labelTemplate(),
+		moduleLabels:     config.moduleLabelTemplate(),
+	}
+	if res, ok := config.externalModuleMaps[key]; ok {
+		config.ExternalModuleMap = res
+		return nil
+	}
+	f, err := os.Open(filepath.Join(repoRoot, mapPath))
 	if err != nil {
-		return nil, fmt.Errorf("opening Python external module map: %w", err)
+		return fmt.Errorf("opening Python external module map: %w", err)
 	}
 	defer f.Close()
 
-	readerFn := readExternalModuleMapTSV
-	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
-		readerFn = readExternalModuleMapYaml
+	var res map[string]ExternalModule
+	if ext := filepath.Ext(mapPath); ext == ".yaml" || ext == ".yml" {
+		var requirements io.Reader
+		if config.RequirementsPath != "" {
+			rf, err := os.Open(filepath.Join(repoRoot, config.RequirementsPath))
+			if err != nil {
+				return fmt.Errorf("opening requirements file for Python external module manifest: %w", err)
+			}
+			defer rf.Close()
+			requirements = rf
+		}
+		res, err = readExternalModuleMapYaml(f, config.labelTemplate(), requirements)
+	} else {
+		res, err = readExternalModuleMapTSV(f, config.labelTemplate(), config.moduleLabelTemplate())
 	}
-	res, err := readerFn(f, namePrefix)
 	if err != nil {
-		return nil, fmt.Errorf("parsing Python external module manifest at path %q: %v", path, err)
+		return fmt.Errorf("parsing Python external module manifest at path %q: %w", mapPath, err)
 	}
-	return res, nil
+	if config.externalModuleMaps != nil {
+		config.externalModuleMaps[key] = res
+	}
+	config.ExternalModuleMap = res
+	return nil
 }
 
-func readExternalModuleMapTSV(r io.Reader, namePrefix string) (map[string]ExternalModule, error) {
+// Reads the TSV external module map, with records of distribution name,
+// package, module name, module type, and optionally the modules in the same
+// distribution which the module depends on. Distributions with the latter
+// have a rule for each module, labelled as given by moduleLabels if its
+// template is not empty.
+func readExternalModuleMapTSV(r io.Reader, labels, moduleLabels labelTemplate) (map[string]ExternalModule, error) {
 	csvR := csv.NewReader(r)
 	csvR.Comma = '\t'
 	csvR.Comment = '#'
+	csvR.FieldsPerRecord = -1
 	allRecords, err := csvR.ReadAll()
 	if err != nil {
 		return nil, err
 	}
 
 	res := make(map[string]ExternalModule)
+	// Distributions which provide modules within each package.
+	subModuleDists := make(map[string]map[string]struct{})
 	for _, records := range allRecords {
-		// We currently don't care if the module is .py or .so, but might in the
-		// future if we figure out how to get fine grained deps from .so
-		// modules, and can then create fine-grained py_library rules in
-		// installed distributions.
-		dist, pkg, moduleName, typ := records[0], records[1], records[2], records[3]
+		if len(records) != 4 && len(records) != 5 {
+			return nil, fmt.Errorf("expected 4 or 5 fields in record %q", records)
+		}
+		dist, pkg, moduleName, typ := internal.NormalizeDistName(records[0], internal.DistNameDash), records[1], records[2], records[3]
 		importSpec := internal.ImportSpec(pkg, moduleName)
-		if val, exists := res[importSpec]; exists {
-			if val.Type == typ {
-				return nil, fmt.Errorf("duplicate entries in Python external module manifest for %q: %v and %v", importSpec, val.Dist, dist)
-			} else {
-				continue
+		target := labels.label(dist, importSpec)
+		if len(records) == 5 && moduleLabels.Template != "" {
+			// The distribution has a rule for each module, which depends on
+			// the rules for the modules it imports.
+			target = moduleLabels.label(dist, importSpec)
+		}
+		for prefix := importSpec; strings.Contains(prefix, "."); {
+			prefix = prefix[:strings.LastIndexByte(prefix, '.')]
+			if subModuleDists[prefix] == nil {
+				subModuleDists[prefix] = make(map[string]struct{})
 			}
+			subModuleDists[prefix][dist] = struct{}{}
 		}
 		module := ExternalModule{
 			Dist:        dist,
 			PkgPath:     strings.ReplaceAll(pkg, ".", "/"),
 			Module:      moduleName,
-			BazelTarget: fmt.Sprintf("@%s%s//:pkg", namePrefix, dist),
+			BazelTarget: target,
 			Type:        typ,
 		}
+		val, exists := res[importSpec]
+		if !exists {
+			res[importSpec] = module
+			continue
+		}
+		if existing, ok := val.provider(dist); ok {
+			if existing.Type == typ {
+				return nil, fmt.Errorf("duplicate entries in Python external module manifest for %q from %v", importSpec, dist)
+			}
+			continue
+		}
+		// Keep the providers sorted by distribution name.
+		providers := append([]ExternalModule{val}, val.OtherProviders...)
+		providers[0].OtherProviders = nil
+		providers = append(providers, module)
+		sort.Slice(providers, func(i, j int) bool {
+			return providers[i].Dist < providers[j].Dist
+		})
+		val = providers[0]
+		val.OtherProviders = providers[1:]
+		res[importSpec] = val
+	}
+	// A package with multiple providers is a namespace package if each of
+	// the providers also provides modules within it.
+	for importSpec, module := range res {
+		if len(module.OtherProviders) == 0 || module.Module != "" {
+			continue
+		}
+		module.Namespace = true
+		for _, dist := range module.dists() {
+			if _, ok := subModuleDists[importSpec][dist]; !ok {
+				module.Namespace = false
+				break
+			}
+		}
 		res[importSpec] = module
 	}
 	return res, nil
 }
 
-func readExternalModuleMapYaml(r io.Reader, namePrefix string) (map[string]ExternalModule, error) {
-	type manifest struct {
-		ModulesMapping map[string]string `yaml:"modules_mapping"`
-	}
-	type container struct {
-		Manifest *manifest `yaml:"manifest"`
-	}
-	var c container
+// pipManifestFile is the schema of the manifest generated for the Gazelle
+// extension in rules_python, e.g.
+//
+//	manifest:
+//	  modules_mapping:
+//	    yaml: PyYAML
+//	  pip_repository:
+//	    name: pip
+//	integrity: 5e0f...
+//
+// The fields of the manifest are in the order in which they are generated,
+// for computing the integrity hash with the same YAML encoder (yaml.v2) as
+// the generator.
+type pipManifestFile struct {
+	Manifest *struct {
+		ModulesMapping        map[string]string `yaml:"modules_mapping"`
+		PipDepsRepositoryName string            `yaml:"pip_deps_repository_name,omitempty"`
+		PipRepository         *struct {
+			Name                    string `yaml:"name"`
+			UsePipRepositoryAliases bool   `yaml:"use_pip_repository_aliases,omitempty"`
+		} `yaml:"pip_repository,omitempty"`
+	} `yaml:"manifest"`
+	Integrity string `yaml:"integrity"`
+}
+
+// Reads the YAML manifest for external modules. If the name prefix for the
+// labels is empty, the name of the pip repository in the manifest followed by
+// "_" is used as the prefix. If requirements is not nil, the integrity hash in the manifest is
+// verified against it.
+func readExternalModuleMapYaml(r io.Reader, labels labelTemplate, requirements io.Reader) (map[string]ExternalModule, error) {
+	var f pipManifestFile
 	decoder := yaml.NewDecoder(r)
-	if err := decoder.Decode(&c); err != nil {
+	if err := decoder.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
 		return nil, fmt.Errorf("failed to decode yaml manifest file: %w", err)
 	}
-	res := make(map[string]ExternalModule)
-	for k, v := range c.Manifest.ModulesMapping {
-		res[k] = ExternalModule{
-			Dist: v,
+	if f.Manifest == nil {
+		return nil, fmt.Errorf("missing manifest")
+	}
+	if requirements != nil {
+		if f.Integrity == "" {
+			return nil, fmt.Errorf("missing integrity hash to verify against requirements file")
 		}
+		integrity, err := f.integrity(requirements)
+		if err != nil {
+			return nil, err
+		}
+		if integrity != f.Integrity {
+			return nil, fmt.Errorf("integrity hash %q in the manifest does not match %q computed with the requirements file; the manifest is stale and needs to be regenerated", f.Integrity, integrity)
+		}
+	}
+	if labels.NamePrefix == "" && f.Manifest.PipRepository != nil && f.Manifest.PipRepository.Name != "" {
+		labels.NamePrefix = f.Manifest.PipRepository.Name + "_"
+	}
+	res := make(map[string]ExternalModule)
+	for importSpec, dist := range f.Manifest.ModulesMapping {
+		res[importSpec] = newExternalModule(importSpec, dist, labels)
 	}
 	return res, nil
 }
+
+// Returns the integrity hash of the manifest for the requirements file from
+// which it was generated; a hex encoded SHA-256 sum of the YAML encoded
+// manifest followed by the contents of the requirements file. The manifest is
+// encoded with yaml.v2, as in the generator; yaml.v3 differs in the output,
+// e.g. it does not fold long scalars.
+func (f *pipManifestFile) integrity(requirements io.Reader) (string, error) {
+	hash := sha256.New()
+	encoder := yamlv2.NewEncoder(hash)
+	if err := encoder.Encode(f.Manifest); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(hash, requirements); err != nil {
+		return "", fmt.Errorf("reading requirements file: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
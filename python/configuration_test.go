@@ -15,21 +15,62 @@
 package python
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
 	"strings"
 	"testing"
 
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/google/go-cmp/cmp"
+	"github.com/siddharthab/bazel-gazelle-python/internal"
 )
 
 func TestReadManifest(t *testing.T) {
 	testCases := []struct {
-		content string
-		prefix  string
-		want    map[string]ExternalModule
+		content        string
+		prefix         string
+		template       string
+		moduleTemplate string
+		want           map[string]ExternalModule
 	}{
 		{
 			want: map[string]ExternalModule{},
 		},
+		{
+			// Rules for each module of distributions with a module
+			// dependency graph.
+			content:        "big-dist\tbig\t\tpy\t\nbig-dist\tbig\tcore\tpy\tbig\nsmall\tsmall\t\tpy",
+			prefix:         "pip_",
+			moduleTemplate: "@{prefix}{normalized_dist}//:{module}",
+			want: map[string]ExternalModule{
+				"big":      {Dist: "big-dist", PkgPath: "big", BazelTarget: "@pip_big_dist//:big", Type: "py"},
+				"big.core": {Dist: "big-dist", PkgPath: "big", Module: "core", BazelTarget: "@pip_big_dist//:big.core", Type: "py"},
+				"small":    {Dist: "small", PkgPath: "small", BazelTarget: "@pip_small//:pkg", Type: "py"},
+			},
+		},
+		{
+			// Without a template for modules, the labels for distributions
+			// are used.
+			content: "big-dist\tbig\tcore\tpy\tbig",
+			prefix:  "pip_",
+			want: map[string]ExternalModule{
+				"big.core": {Dist: "big-dist", PkgPath: "big", Module: "core", BazelTarget: "@pip_big_dist//:pkg", Type: "py"},
+			},
+		},
+		{
+			content:  "PyYAML\tyaml\t\tpy",
+			prefix:   "pypi_",
+			template: "@{hub}//{normalized_dist}",
+			want: map[string]ExternalModule{
+				"yaml": {
+					Dist:        "pyyaml",
+					PkgPath:     "yaml",
+					BazelTarget: "@pypi//pyyaml",
+					Type:        "py",
+				},
+			},
+		},
 		{
 			content: "dist1\tpkg1.pkg2\tmod\tpy\ndist2\t\tmod\tso\ndist3\tpkg\t\tpy",
 			prefix:  "pre_",
@@ -57,10 +98,41 @@ func TestReadManifest(t *testing.T) {
 				},
 			},
 		},
+		{
+			// A namespace package shared by distributions, and a module in
+			// conflict between distributions.
+			content: "zope.interface\tzope\t\tpy\nzope.interface\tzope.interface\t\tpy\nzope.event\tzope\t\tpy\nzope.event\tzope.event\t\tpy\n" +
+				"six\t\tsix\tpy\nsix-fork\t\tsix\tpy\nsix-fork\tsix_fork\t\tpy",
+			prefix: "pip_",
+			want: map[string]ExternalModule{
+				"zope": {
+					Dist:        "zope-event",
+					PkgPath:     "zope",
+					BazelTarget: "@pip_zope_event//:pkg",
+					Type:        "py",
+					OtherProviders: []ExternalModule{
+						{Dist: "zope-interface", PkgPath: "zope", BazelTarget: "@pip_zope_interface//:pkg", Type: "py"},
+					},
+					Namespace: true,
+				},
+				"zope.interface": {Dist: "zope-interface", PkgPath: "zope/interface", BazelTarget: "@pip_zope_interface//:pkg", Type: "py"},
+				"zope.event":     {Dist: "zope-event", PkgPath: "zope/event", BazelTarget: "@pip_zope_event//:pkg", Type: "py"},
+				"six": {
+					Dist:        "six",
+					Module:      "six",
+					BazelTarget: "@pip_six//:pkg",
+					Type:        "py",
+					OtherProviders: []ExternalModule{
+						{Dist: "six-fork", Module: "six", BazelTarget: "@pip_six_fork//:pkg", Type: "py"},
+					},
+				},
+				"six_fork": {Dist: "six-fork", PkgPath: "six_fork", BazelTarget: "@pip_six_fork//:pkg", Type: "py"},
+			},
+		},
 	}
 
 	for i, testCase := range testCases {
-		got, err := readExternalModuleMapTSV(strings.NewReader(testCase.content), testCase.prefix)
+		got, err := readExternalModuleMapTSV(strings.NewReader(testCase.content), labelTemplate{Template: testCase.template, NamePrefix: testCase.prefix}, labelTemplate{Template: testCase.moduleTemplate, NamePrefix: testCase.prefix})
 		if err != nil {
 			t.Errorf("test %d: unexpected error: %v", i, err)
 			continue
@@ -70,3 +142,241 @@ func TestReadManifest(t *testing.T) {
 		}
 	}
 }
+
+func TestReadManifestFields(t *testing.T) {
+	content := "six\t\tsix"
+	_, err := readExternalModuleMapTSV(strings.NewReader(content), labelTemplate{}, labelTemplate{})
+	if err == nil || !strings.Contains(err.Error(), "expected 4 or 5 fields") {
+		t.Errorf("got error %v, want error for number of fields", err)
+	}
+}
+
+func TestReadManifestDuplicate(t *testing.T) {
+	content := "six\t\tsix\tpy\nsix\t\tsix\tso\nsix\t\tsix\tpy"
+	_, err := readExternalModuleMapTSV(strings.NewReader(content), labelTemplate{}, labelTemplate{})
+	if err == nil || !strings.Contains(err.Error(), "duplicate entries") {
+		t.Errorf("got error %v, want error for duplicate entries", err)
+	}
+}
+
+func TestReadManifestYaml(t *testing.T) {
+	requirements := "PyYAML==6.0\n"
+	// The manifest as encoded by the generator, followed by the requirements.
+	sum := sha256.Sum256([]byte("modules_mapping:\n  yaml: PyYAML\n  yaml.composer: PyYAML\npip_repository:\n  name: pip\n" + requirements))
+	integrity := hex.EncodeToString(sum[:])
+	content := `# Generated file.
+manifest:
+  modules_mapping:
+    yaml: PyYAML
+    yaml.composer: PyYAML
+  pip_repository:
+    name: pip
+integrity: ` + integrity + "\n"
+
+	testCases := []struct {
+		content      string
+		prefix       string
+		requirements string
+		want         map[string]ExternalModule
+		wantErr      string
+	}{
+		{
+			content: content,
+			want: map[string]ExternalModule{
+				"yaml":          {Dist: "pyyaml", PkgPath: "yaml", BazelTarget: "@pip_pyyaml//:pkg", Type: "py"},
+				"yaml.composer": {Dist: "pyyaml", PkgPath: "yaml/composer", BazelTarget: "@pip_pyyaml//:pkg", Type: "py"},
+			},
+		},
+		{
+			content:      content,
+			prefix:       "pypi_",
+			requirements: requirements,
+			want: map[string]ExternalModule{
+				"yaml":          {Dist: "pyyaml", PkgPath: "yaml", BazelTarget: "@pypi_pyyaml//:pkg", Type: "py"},
+				"yaml.composer": {Dist: "pyyaml", PkgPath: "yaml/composer", BazelTarget: "@pypi_pyyaml//:pkg", Type: "py"},
+			},
+		},
+		{
+			content:      content,
+			requirements: "PyYAML==6.0.1\n",
+			wantErr:      "manifest is stale",
+		},
+		{
+			content:      "manifest:\n  modules_mapping:\n    yaml: PyYAML\n",
+			requirements: requirements,
+			wantErr:      "missing integrity hash",
+		},
+		{
+			content: "integrity: abc\n",
+			wantErr: "missing manifest",
+		},
+	}
+
+	for i, testCase := range testCases {
+		var requirements io.Reader
+		if testCase.requirements != "" {
+			requirements = strings.NewReader(testCase.requirements)
+		}
+		got, err := readExternalModuleMapYaml(strings.NewReader(testCase.content), labelTemplate{NamePrefix: testCase.prefix}, requirements)
+		if testCase.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), testCase.wantErr) {
+				t.Errorf("test %d: got error %v, want error containing %q", i, err, testCase.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if diff := cmp.Diff(got, testCase.want); diff != "" {
+			t.Errorf("test %d: (-got, +want):%s", i, diff)
+		}
+	}
+}
+
+func TestReadManifestYamlIntegrity(t *testing.T) {
+	// A manifest as written by the generator in rules_python, whose YAML
+	// encoder folds the long scalar when computing the integrity hash.
+	content := `# GENERATED FILE - DO NOT EDIT!
+#
+# To update this file, run:
+#   bazel run //:gazelle_python_manifest.update
+
+manifest:
+  modules_mapping:
+    google.cloud.bigquery_storage_v1.services.big_query_read.transports.grpc_asyncio: google_cloud_bigquery_storage
+    tests: a value with spaces which is long enough to be folded by the yaml.v2 encoder
+      at the limit
+    yaml: PyYAML
+    yaml.composer: PyYAML
+  pip_repository:
+    name: pip
+integrity: 4edd7dd873b7c175f9bdcc3e1c6403bacd22f8ca2252f6800f11f02884e0c186
+`
+	requirements := "google-cloud-bigquery-storage==2.19.1\nPyYAML==6.0\n"
+	got, err := readExternalModuleMapYaml(strings.NewReader(content), labelTemplate{}, strings.NewReader(requirements))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 {
+		t.Errorf("got %d modules, want 4", len(got))
+	}
+}
+
+func TestLabelTemplate(t *testing.T) {
+	testCases := []struct {
+		labels labelTemplate
+		dist   string
+		imp    string
+		want   string
+	}{
+		{labelTemplate{NamePrefix: "pip_"}, "PyYAML", "yaml", "@pip_pyyaml//:pkg"},
+		{labelTemplate{NamePrefix: "pip_"}, "ruamel.yaml", "ruamel.yaml", "@pip_ruamel_yaml//:pkg"},
+		{labelTemplate{Template: "@{prefix}{dist}//:pkg", NamePrefix: "pip_"}, "ruamel.yaml", "ruamel.yaml", "@pip_ruamel-yaml//:pkg"},
+		{labelTemplate{Template: "@{hub}//{normalized_dist}", NamePrefix: "pip_"}, "ruamel.yaml", "ruamel.yaml", "@pip//ruamel_yaml"},
+		{labelTemplate{Template: "@{hub}//{normalized_dist}:pkg", NamePrefix: "pypi"}, "Typing--Extensions", "typing_extensions", "@pypi//typing_extensions:pkg"},
+		{labelTemplate{Template: "@{hub}//{normalized_dist}", NamePrefix: "pypi", DistNameStyle: internal.DistNameDash}, "typing_extensions", "typing_extensions", "@pypi//typing-extensions"},
+		{labelTemplate{Template: "@{hub}//{normalized_dist}", NamePrefix: "pypi", DistNameStyle: internal.DistNameLowercase}, "Zope.Interface", "zope.interface", "@pypi//zope-interface"},
+		{labelTemplate{Template: "@{prefix}{normalized_dist}//:{module}", NamePrefix: "pip_"}, "google-cloud-storage", "google.cloud.storage", "@pip_google_cloud_storage//:google.cloud.storage"},
+	}
+
+	for i, testCase := range testCases {
+		got := testCase.labels.label(testCase.dist, testCase.imp)
+		if diff := cmp.Diff(got, testCase.want); diff != "" {
+			t.Errorf("test %d: (-got, +want):%s", i, diff)
+		}
+	}
+
+	for _, template := range []string{"@{hub}//{normalized_dist}", defaultExternalLabelTemplate} {
+		if err := (labelTemplate{Template: template}).validate(); err != nil {
+			t.Errorf("unexpected error for template %q: %v", template, err)
+		}
+	}
+	if err := (labelTemplate{Template: "@{hub}//{dist}:a:b"}).validate(); err == nil {
+		t.Errorf("expected error for invalid template")
+	}
+}
+
+func TestParseRuleDeps(t *testing.T) {
+	testCases := []struct {
+		value    string
+		wantName string
+		want     RuleDeps
+		wantErr  bool
+	}{
+		{
+			value:    "foo +//pkg:bar -@pip_numpy//:pkg +:baz",
+			wantName: "foo",
+			want: RuleDeps{
+				Add:    []label.Label{{Pkg: "pkg", Name: "bar"}, {Name: "baz", Relative: true}},
+				Remove: []label.Label{{Repo: "pip_numpy", Name: "pkg"}},
+			},
+		},
+		{value: "foo", wantErr: true},
+		{value: "foo //pkg:bar", wantErr: true},
+		{value: "foo +//pkg:bar:baz", wantErr: true},
+	}
+
+	for i, testCase := range testCases {
+		gotName, got, err := parseRuleDeps(testCase.value)
+		if testCase.wantErr {
+			if err == nil {
+				t.Errorf("test %d: expected error for %q", i, testCase.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if gotName != testCase.wantName {
+			t.Errorf("test %d: got name %q, want %q", i, gotName, testCase.wantName)
+		}
+		if diff := cmp.Diff(got, testCase.want); diff != "" {
+			t.Errorf("test %d: (-got, +want):%s", i, diff)
+		}
+	}
+}
+
+func TestRootDirFor(t *testing.T) {
+	config := Configuration{RootDir: "", Roots: []string{"src", "tools/python", "src/vendored"}}
+	testCases := []struct {
+		dir          string
+		want         string
+		wantPriority int
+	}{
+		{"", "", 3},
+		{"docs", "", 3},
+		{"src", "src", 0},
+		{"src/app", "src", 0},
+		{"srcs/app", "", 3},
+		{"src/vendored/six", "src/vendored", 2},
+		{"tools/python/lint", "tools/python", 1},
+	}
+	for i, tc := range testCases {
+		got := config.rootDirFor(tc.dir)
+		if got != tc.want {
+			t.Errorf("test %d: got root %q, want %q", i, got, tc.want)
+		}
+		rootConfig := config
+		rootConfig.RootDir = got
+		if priority := rootConfig.rootPriority(); priority != tc.wantPriority {
+			t.Errorf("test %d: got priority %d, want %d", i, priority, tc.wantPriority)
+		}
+	}
+	// A deeper root set by directive takes precedence.
+	config.RootDir = "src/app/lib"
+	if got := config.rootDirFor("src/app/lib/x"); got != "src/app/lib" {
+		t.Errorf("got root %q, want %q", got, "src/app/lib")
+	}
+}
+
+func TestRootsFlag(t *testing.T) {
+	var roots []string
+	if err := (*rootsFlag)(&roots).Set("src, tools/python/,,third_party/py"); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(roots, []string{"src", "tools/python", "third_party/py"}); diff != "" {
+		t.Errorf("(-got, +want):%s", diff)
+	}
+}
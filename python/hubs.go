@@ -0,0 +1,116 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package python
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// An external dependency hub is the set of external distributions locked
+// together, e.g. a pip hub repository generated from one requirements file.
+// Each directory is bound to one hub, identified by the path of its external
+// module map, and a rule must not depend on external modules from more than
+// one hub, directly or through other generated rules, as the distributions
+// may conflict.
+
+// Names of the files which mark the root of a tree of directories bound to
+// the external module map next to them.
+const (
+	requirementsFileName = "requirements.txt"
+	pyprojectFileName    = "pyproject.toml"
+)
+
+// Finds the external module map with the given file name in the directory
+// rel, if the directory also has a requirements.txt or pyproject.toml. Returns
+// the path to the map, and to the requirements file if present, relative to
+// the repository root.
+func findExternalModuleMap(repoRoot, rel, name string) (string, string, bool) {
+	dir := filepath.Join(repoRoot, filepath.FromSlash(rel))
+	if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+		return "", "", false
+	}
+	var requirementsPath string
+	if _, err := os.Stat(filepath.Join(dir, requirementsFileName)); err == nil {
+		requirementsPath = path.Join(rel, requirementsFileName)
+	} else if _, err := os.Stat(filepath.Join(dir, pyprojectFileName)); err != nil {
+		return "", "", false
+	}
+	return path.Join(rel, name), requirementsPath, true
+}
+
+// externalModuleMapKey is the input from which an external module map is
+// read.
+type externalModuleMapKey struct {
+	mapPath          string
+	requirementsPath string
+	labels           labelTemplate
+	moduleLabels     labelTemplate
+}
+
+// externalModuleMapCache holds the external module maps which have been read,
+// so that directories bound to the same map share it. It is shared by all
+// copies of the configuration.
+type externalModuleMapCache map[externalModuleMapKey]map[string]ExternalModule
+
+// hubDeps records, for each generated rule, the hub of its external
+// dependencies and its dependencies on other rules, to find the rules which
+// depend on more than one hub once all rules are resolved.
+type hubDeps map[string]hubRule
+
+type hubRule struct {
+	hub  string   // Empty if the rule has no external dependencies.
+	deps []string // Labels of the dependencies, other than external ones.
+}
+
+// Returns the hubs of the rules which depend on more than one hub, directly
+// or transitively, keyed by label.
+func (h hubDeps) conflicts() map[string][]string {
+	hubs := make(map[string]map[string]struct{}, len(h))
+	for target, r := range h {
+		hubs[target] = make(map[string]struct{})
+		if r.hub != "" {
+			hubs[target][r.hub] = struct{}{}
+		}
+	}
+	// Propagate the hubs of dependencies until there are no changes; the
+	// number of hubs is small.
+	for changed := true; changed; {
+		changed = false
+		for target, r := range h {
+			for _, dep := range r.deps {
+				for hub := range hubs[dep] {
+					if _, ok := hubs[target][hub]; !ok {
+						hubs[target][hub] = struct{}{}
+						changed = true
+					}
+				}
+			}
+		}
+	}
+	res := make(map[string][]string)
+	for target, targetHubs := range hubs {
+		if len(targetHubs) <= 1 {
+			continue
+		}
+		for hub := range targetHubs {
+			res[target] = append(res[target], hub)
+		}
+		sort.Strings(res[target])
+	}
+	return res
+}
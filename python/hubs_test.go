@@ -0,0 +1,123 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package python
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHubConflicts(t *testing.T) {
+	testCases := []struct {
+		deps hubDeps
+		want map[string][]string
+	}{
+		{
+			deps: hubDeps{
+				"//a:lib":    {hub: "a/map.yaml"},
+				"//b:lib":    {hub: "b/map.yaml"},
+				"//shared:x": {},
+				"//a:bin":    {hub: "a/map.yaml", deps: []string{"//a:lib", "//shared:x", "@pip//:yaml"}},
+			},
+			want: map[string][]string{},
+		},
+		{
+			deps: hubDeps{
+				"//a:lib": {hub: "a/map.yaml"},
+				"//b:lib": {hub: "b/map.yaml", deps: []string{"//c:lib"}},
+				"//c:lib": {deps: []string{"//a:lib"}},
+			},
+			want: map[string][]string{"//b:lib": {"a/map.yaml", "b/map.yaml"}},
+		},
+		{
+			// Cycles between rules.
+			deps: hubDeps{
+				"//a:lib": {hub: "a/map.yaml", deps: []string{"//b:lib"}},
+				"//b:lib": {hub: "b/map.yaml", deps: []string{"//a:lib"}},
+			},
+			want: map[string][]string{
+				"//a:lib": {"a/map.yaml", "b/map.yaml"},
+				"//b:lib": {"a/map.yaml", "b/map.yaml"},
+			},
+		},
+	}
+	for i, testCase := range testCases {
+		got := testCase.deps.conflicts()
+		if diff := cmp.Diff(got, testCase.want); diff != "" {
+			t.Errorf("test %d: (-got, +want):%s", i, diff)
+		}
+	}
+}
+
+func TestFindExternalModuleMap(t *testing.T) {
+	repoRoot := t.TempDir()
+	for _, name := range []string{"a/requirements.txt", "a/map.yaml", "b/pyproject.toml", "b/map.yaml", "c/map.yaml"} {
+		p := filepath.Join(repoRoot, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testCases := []struct {
+		rel, wantMapPath, wantRequirementsPath string
+		wantOk                                 bool
+	}{
+		{rel: "a", wantMapPath: "a/map.yaml", wantRequirementsPath: "a/requirements.txt", wantOk: true},
+		{rel: "b", wantMapPath: "b/map.yaml", wantOk: true},
+		{rel: "c"},
+		{rel: "d"},
+	}
+	for i, testCase := range testCases {
+		mapPath, requirementsPath, ok := findExternalModuleMap(repoRoot, testCase.rel, "map.yaml")
+		got := []interface{}{mapPath, requirementsPath, ok}
+		want := []interface{}{testCase.wantMapPath, testCase.wantRequirementsPath, testCase.wantOk}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("test %d: (-got, +want):%s", i, diff)
+		}
+	}
+}
+
+func TestExternalModuleMapCache(t *testing.T) {
+	repoRoot := t.TempDir()
+	mapPath := filepath.Join(repoRoot, "modules.tsv")
+	if err := os.WriteFile(mapPath, []byte("requests\trequests\t\tpy\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config := Configuration{ExternalModuleMapPath: "modules.tsv", externalModuleMaps: make(externalModuleMapCache)}
+	if err := config.readExternalModuleMap(repoRoot); err != nil {
+		t.Fatal(err)
+	}
+	// The map is not read again for the same inputs.
+	if err := os.Remove(mapPath); err != nil {
+		t.Fatal(err)
+	}
+	other := Configuration{ExternalModuleMapPath: "modules.tsv", externalModuleMaps: config.externalModuleMaps}
+	if err := other.readExternalModuleMap(repoRoot); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(other.ExternalModuleMap, config.ExternalModuleMap); diff != "" {
+		t.Errorf("(-got, +want):%s", diff)
+	}
+	// The map is read again for a different label template.
+	other.ExternalLabelTemplate = "@{hub}//{normalized_dist}"
+	if err := other.readExternalModuleMap(repoRoot); err == nil {
+		t.Error("expected error for map read again from removed file")
+	}
+}
@@ -28,13 +28,11 @@ const (
 
 var kinds = map[string]rule.KindInfo{
 	kindPyLibrary: {
-		// We can not rely on sources as the match attribute, because
-		// multiple targets may have the same sources if there is a cyclical
-		// dependency between modules in the package. For example, a.py and
-		// b.py can both depend on each other, and so py_library for both a
-		// and b with each list both a.py and b.py.
-		// TODO: Change this logic when we start having one src file per rule.
-		// So match by the rule name instead (default).
+		// Rules are matched by name (default). Sources are not a reliable
+		// match attribute because the set of sources for a rule changes as
+		// import cycles in the package are formed or broken. Dependencies
+		// are not a resolved attribute, as they may have select()
+		// expressions which Gazelle cannot merge; the resolver merges them.
 		NonEmptyAttrs: map[string]bool{
 			"srcs": true,
 		},
@@ -42,9 +40,6 @@ var kinds = map[string]rule.KindInfo{
 			"srcs":    true,
 			"imports": true,
 		},
-		ResolveAttrs: map[string]bool{
-			"deps": true,
-		},
 	},
 	kindPyBinary: {
 		NonEmptyAttrs: map[string]bool{
@@ -55,9 +50,6 @@ var kinds = map[string]rule.KindInfo{
 			"imports": true,
 			"main":    true,
 		},
-		ResolveAttrs: map[string]bool{
-			"deps": true,
-		},
 	},
 	kindPyTest: {
 		NonEmptyAttrs: map[string]bool{
@@ -67,9 +59,6 @@ var kinds = map[string]rule.KindInfo{
 			"srcs":    true,
 			"imports": true,
 		},
-		ResolveAttrs: map[string]bool{
-			"deps": true,
-		},
 	},
 }
 
@@ -17,15 +17,28 @@ package python
 import (
 	"log"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/language"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/siddharthab/bazel-gazelle-python/internal"
 )
 
 func NewLanguage() language.Language {
-	return &Language{}
+	return &Language{
+		Resolver: Resolver{
+			modules:     make(map[string]*Module),
+			files:       make(map[string]*rule.File),
+			namespaces:  make(map[string]struct{}),
+			roots:       make(map[string]pythonRoot),
+			moduleRoots: make(map[string]pythonRoot),
+			pending:     new(int),
+			unresolved:  &unresolvedImports{},
+			hubs:        make(hubDeps),
+		},
+	}
 }
 
 type Language struct {
@@ -45,22 +58,28 @@ func (l *Language) Loads() []rule.LoadInfo {
 
 // GenerateRules implements language.Language.
 //
-// Generates one py_library (or py_binary) rule for each Python module,
-// including one for __init__.py with name as the last component of the Python
-// package name. If modules within a package depend on each other, then their
-// src files are included in multiple rules, which allows us to have cyclical
-// dependencies in the package. The inefficiency of repeating src files is
-// expected to be minor because py_library is a very thin implementation. An
-// alternative would be to disallow cycles and not repeat src files and depend
-// on the rules for the modules, with the possible exception for __init__.py
-// where cycles are common.
+// Generates one py_library (or py_binary or py_test) rule for each Python
+// module, including one for __init__.py with name as the last component of the
+// Python package name. Modules depend on the rules for the sibling modules
+// that they import. Because Bazel does not allow cyclical dependencies, library
+// modules which import each other cyclically are collapsed into a single
+// py_library rule, named after the first module in the cycle by import
+// specifier, e.g. the package __init__.py if it is part of the cycle. As every
+// module depends on the package __init__.py, the modules which it imports are
+// part of its cycle.
 //
 // Each module also depends on the py_library rule for the package __init__.py,
-// and the package __init__.py depends on its parent package __init__.py.
+// and the package __init__.py depends on its parent package __init__.py. With
+// namespace packages enabled, directories without __init__.py are implicit
+// namespace packages (PEP 420), which have no rule; modules then depend on the
+// closest regular package above, if any, in the same Python root.
+//
+// The modules are also recorded for the resolver, which uses their top-level
+// symbols to resolve imports like `from a import b`.
 //
 // REQUIRES: No cyclical dependencies across packages.
 //
-// REQUIRES: This is not a split namespace package.
+// REQUIRES: This is not a split package, unless it is a namespace package.
 //
 // REQUIRES: PYTHONSAFEPATH is enabled.
 func (l *Language) GenerateRules(args language.GenerateArgs) language.GenerateResult {
@@ -80,23 +99,42 @@ func (l *Language) GenerateRules(args language.GenerateArgs) language.GenerateRe
 	var filenames []string
 	filenames = append(filenames, args.RegularFiles...)
 	filenames = append(filenames, args.GenFiles...)
-	modules := analyzePythonPackage(pkgPath, args.Dir, args.Subdirs, filenames)
+	modules := analyzePythonPackage(pkgPath, args.Dir, args.Subdirs, filenames, config.TestPatterns, config.Parallelism, config.ParseCache, config.NamespacePackages)
+	if config.NamespacePackages && pkgPath != "" && !hasInitFile(filenames) {
+		l.namespaces[internal.ImportSpec(pkgPath, "")] = struct{}{}
+	}
 
-	// Generate a rule for each .py module in this package.
+	// Generate a rule for each .py module, or cycle of modules, in this package.
 	ruleNames := make(map[string]struct{})
-	res := language.GenerateResult{
-		Gen:     make([]*rule.Rule, len(modules)),
-		Imports: make([]interface{}, len(modules)),
-	}
-	for i, module := range modules {
+	var res language.GenerateResult
+	for _, module := range modules {
+		l.modules[module.ImportSpec] = module
 		rule := module.GenerateRule(config.NameTemplate, relRoot)
+		if rule == nil {
+			continue
+		}
 		ruleNames[rule.Name()] = struct{}{}
-		res.Gen[i] = rule
-		res.Imports[i] = module
+		res.Gen = append(res.Gen, rule)
+		res.Imports = append(res.Imports, module)
 	}
+	*l.pending += len(res.Gen)
 
-	// Check if any rules need to be deleted.
+	var unknownRules []string
+	for name := range config.RuleDeps {
+		if _, ok := ruleNames[name]; !ok {
+			unknownRules = append(unknownRules, name)
+		}
+	}
+	sort.Strings(unknownRules)
+	for _, name := range unknownRules {
+		log.Printf("%q directive in %q for rule %q which was not generated", directiveDeps, args.Rel, name)
+	}
+
+	// Record the BUILD file for the resolver, and check if any rules need to
+	// be deleted. New BUILD files are created after generating the rules, and
+	// only recorded when indexing them.
 	if args.File != nil {
+		l.files[args.Rel] = args.File
 		for _, rule := range args.File.Rules {
 			if _, ok := ruleNames[rule.Name()]; ok {
 				// Will be merged with generated rules.
@@ -118,6 +156,16 @@ func (l *Language) Fix(c *config.Config, f *rule.File) {
 	// Nothing to fix.
 }
 
+// Returns true if the files include the __init__.py of a regular package.
+func hasInitFile(filenames []string) bool {
+	for _, filename := range filenames {
+		if filename == "__init__.py" {
+			return true
+		}
+	}
+	return false
+}
+
 func isRuleManaged(rule *rule.Rule) bool {
 	for _, tag := range rule.AttrStrings("tags") {
 		if tag == tagGazelleManaged {
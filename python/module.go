@@ -15,11 +15,13 @@
 package python
 
 import (
+	"log"
 	"path"
 	"sort"
 	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/siddharthab/bazel-gazelle-python/internal"
 	"github.com/siddharthab/bazel-gazelle-python/python/parser"
 )
 
@@ -32,29 +34,87 @@ type Module struct {
 	PkgPath      string
 	Name         string
 	Filename     string
-	InPkgDeps    map[*Module]struct{} // Module deps within the package.
-	ExPkgImports []string             // Import statements not satisfied from within the package.
+	IsTest       bool                 // Whether the module name matches the test patterns.
+	InPkgDeps    map[*Module]struct{} // Direct module deps within the package.
+	ExPkgImports []parser.Import      // Absolute imports not satisfied from within the package.
+	// Absolute type-only imports, including those of modules in the package.
+	AbsTypeOnlyImports []parser.Import
+	// Modules in the same strongly connected component of the InPkgDeps graph,
+	// i.e. modules which import each other cyclically, sorted by import
+	// specifier; every module also depends on the package __init__.py.
+	// Includes this module, if computed.
+	Cycle []*Module
 }
 
-// ProcessImports computes direct InPkgDeps and ExPkgImports.
+// ProcessImports computes direct InPkgDeps, ExPkgImports and
+// AbsTypeOnlyImports. Relative imports are anchored to the package of this
+// module. Imports annotated with `# gazelle:ignore` are skipped, and those
+// annotated with `# gazelle:dep` are external to the package, to be resolved
+// to the labels of the annotations.
 func (module *Module) ProcessImports(moduleMap map[string]*Module, subPackages map[string]struct{}) {
-	for _, imp := range module.Imports {
+	for _, relImp := range module.Imports {
+		if relImp.Ignore {
+			continue
+		}
+		imp, ok := module.absoluteImport(relImp)
+		if !ok {
+			log.Printf("relative import %q in Python module %q goes beyond the Python root", relImp, module.ImportSpec)
+			continue
+		}
+		if len(relImp.Deps) > 0 {
+			module.ExPkgImports = append(module.ExPkgImports, parser.Import{Name: imp, Line: relImp.Line, Dynamic: relImp.Dynamic, Optional: relImp.Optional, Condition: relImp.Condition, Deps: relImp.Deps})
+			continue
+		}
 		dep := module.findInPkgImport(imp, moduleMap, subPackages)
+		if dep == module {
+			continue
+		}
 		if dep != nil {
 			module.InPkgDeps[dep] = struct{}{}
 		} else {
-			module.ExPkgImports = append(module.ExPkgImports, imp)
+			module.ExPkgImports = append(module.ExPkgImports, parser.Import{Name: imp, Line: relImp.Line, Dynamic: relImp.Dynamic, Optional: relImp.Optional, Condition: relImp.Condition})
+		}
+	}
+	for _, relImp := range module.TypeOnlyImports {
+		if relImp.Ignore {
+			continue
+		}
+		imp, ok := module.absoluteImport(relImp)
+		if !ok {
+			log.Printf("relative import %q in Python module %q goes beyond the Python root", relImp, module.ImportSpec)
+			continue
 		}
+		module.AbsTypeOnlyImports = append(module.AbsTypeOnlyImports, parser.Import{Name: imp, Line: relImp.Line, Deps: relImp.Deps})
+	}
+}
+
+// Returns the absolute import specifier for the import. Relative imports are
+// resolved by walking up the parent packages of this module, as many as the
+// level of the import. Returns false if this goes beyond the Python root.
+func (module *Module) absoluteImport(imp parser.Import) (string, bool) {
+	if imp.Level == 0 {
+		return imp.Name, true
+	}
+	var anchor []string
+	if module.PkgPath != "" {
+		anchor = strings.Split(module.PkgPath, "/")
+	}
+	if imp.Level > len(anchor) {
+		return "", false
 	}
+	anchor = anchor[:len(anchor)-imp.Level+1]
+	return internal.ImportSpec(path.Join(anchor...), imp.Name), true
 }
+
+// Returns the module in this package which satisfies the import, or nil. An
+// import of a.b could be for the subpackage or module b in package a, or for
+// the symbol b from module a. Subpackages and modules are preferred over
+// symbols of the same name; this is safe because modules always depend on
+// their parent package.
 func (module *Module) findInPkgImport(imp string, moduleMap map[string]*Module, subPackages map[string]struct{}) *Module {
 	ext := path.Ext(imp)
 	impParent := strings.TrimSuffix(imp, ext)
 	if ext != "" && impParent == strings.ReplaceAll(module.PkgPath, "/", ".") {
-		// This could be a symbol from this package, or it could be a subpackage.
-		// Preference is symbol > subpackage > module.
-		// We currently do not parse symbols out of the modules, so let's ignore that.
-		// TODO: Parse global symbols from the module into parser.Result; this will be tricky.
 		if _, ok := subPackages[ext[1:]]; ok {
 			// This is a subpackage.
 			return nil
@@ -64,38 +124,120 @@ func (module *Module) findInPkgImport(imp string, moduleMap map[string]*Module,
 	if ok {
 		return dep
 	}
+	if ext == "" {
+		return nil
+	}
 	dep, ok = moduleMap[impParent]
-	if ok {
+	if ok && dep.DefinesSymbol(ext[1:]) {
 		return dep
 	}
 	return nil
 }
 
-// DepsClosure expands the ModuleDeps to get all transitive deps.
-func (module *Module) DepsClosure() {
-	for dep := range module.InPkgDeps {
-		module.depsClosureHelper(dep)
+// DefinesSymbol returns true if the name may be imported from this module, as
+// determined from its top-level symbols. Modules with wildcard imports or a
+// module level __getattr__ function may define any name.
+func (module *Module) DefinesSymbol(name string) bool {
+	if name == "*" {
+		return true
 	}
+	for _, symbol := range module.Symbols {
+		if symbol == name || symbol == "*" || symbol == "__getattr__" {
+			return true
+		}
+	}
+	return false
 }
-func (module *Module) depsClosureHelper(dep *Module) {
-	for depdep := range dep.InPkgDeps {
-		if _, ok := module.InPkgDeps[depdep]; ok || depdep == module {
-			// Cycle.
-			continue
+
+// ComputeCycles sets the Cycle for each module from the strongly connected
+// components of the InPkgDeps graph. The graph also has an edge from each
+// module to the package __init__.py, as the rule of every module depends on
+// the rule of its package; modules imported by __init__.py are then in its
+// cycle.
+func ComputeCycles(modules []*Module) {
+	var pkgInit *Module
+	moduleMap := make(map[string]*Module, len(modules))
+	var importSpecs []string
+	for _, module := range modules {
+		if module.Name == "" {
+			pkgInit = module
+		}
+		moduleMap[module.ImportSpec] = module
+		importSpecs = append(importSpecs, module.ImportSpec)
+	}
+	deps := func(importSpec string) []string {
+		module := moduleMap[importSpec]
+		var res []string
+		for dep := range module.InPkgDeps {
+			res = append(res, dep.ImportSpec)
+		}
+		if pkgInit != nil && module != pkgInit {
+			res = append(res, pkgInit.ImportSpec)
+		}
+		return res
+	}
+	for _, specs := range internal.Cycles(importSpecs, deps) {
+		cycle := make([]*Module, len(specs))
+		for i, spec := range specs {
+			cycle[i] = moduleMap[spec]
+		}
+		for _, member := range cycle {
+			member.Cycle = cycle
 		}
-		module.InPkgDeps[depdep] = struct{}{}
-		module.depsClosureHelper(depdep)
 	}
 }
 
-func (module Module) GenerateRule(nameTemplate, relPythonRoot string) *rule.Rule {
-	var kind = kindPyLibrary
-	if module.Name == "__test__" || strings.HasPrefix(module.Name, "test_") || strings.HasSuffix(module.Name, "_test") {
-		kind = kindPyTest
+// Kind returns the kind of the rule for this module, as given by the
+// `# gazelle:py_kind` pragma if any.
+func (module *Module) Kind() string {
+	if module.RuleKind != "" {
+		return module.RuleKind
+	}
+	if module.IsTest {
+		return kindPyTest
 	} else if module.Name == "__main__" || module.HasMainNameCheck {
-		kind = kindPyBinary
+		return kindPyBinary
+	}
+	return kindPyLibrary
+}
+
+// Returns the first library module in the cycle of this module, if the module
+// is part of a cycle. The rule for this module includes the sources of all the
+// modules in the cycle.
+func (module *Module) cycleOwner() *Module {
+	if len(module.Cycle) <= 1 {
+		return nil
+	}
+	for _, member := range module.Cycle {
+		if member.Kind() == kindPyLibrary {
+			return member
+		}
+	}
+	return nil
+}
+
+// Returns the module with the rule which provides this module to others.
+func (module *Module) ruleOwner() *Module {
+	if owner := module.cycleOwner(); owner != nil {
+		return owner
+	}
+	return module
+}
+
+// Returns the modules with sources in the rule for this module. Binary and test
+// modules in a cycle with library modules keep their own rule, which depends on
+// the rule for the cycle. If there are no library modules in the cycle, each
+// of them includes the sources of the whole cycle.
+func (module *Module) srcModules() []*Module {
+	owner := module.cycleOwner()
+	if owner == module || (owner == nil && len(module.Cycle) > 1) {
+		return module.Cycle
 	}
+	return []*Module{module}
+}
 
+// Returns the name of the rule for this module.
+func (module *Module) ruleName(nameTemplate string) string {
 	name := module.Name
 	pkgName := path.Base(module.PkgPath)
 	switch name {
@@ -106,9 +248,18 @@ func (module Module) GenerateRule(nameTemplate, relPythonRoot string) *rule.Rule
 	case "__test__":
 		name = pkgName + "_test"
 	}
-	name = strings.ReplaceAll(nameTemplate, "{module_name}", name)
+	return strings.ReplaceAll(nameTemplate, "{module_name}", name)
+}
+
+// GenerateRule returns the rule for this module, or nil if this module is a
+// library in a cycle owned by another module.
+func (module *Module) GenerateRule(nameTemplate, relPythonRoot string) *rule.Rule {
+	kind := module.Kind()
+	if kind == kindPyLibrary && module.ruleOwner() != module {
+		return nil
+	}
 
-	rule := rule.NewRule(kind, name)
+	rule := rule.NewRule(kind, module.ruleName(nameTemplate))
 	if kind == kindPyBinary {
 		rule.SetAttr("main", module.Filename)
 	}
@@ -117,9 +268,9 @@ func (module Module) GenerateRule(nameTemplate, relPythonRoot string) *rule.Rule
 		rule.SetAttr("visibility", []string{visibilityPublic})
 	}
 
-	srcs := []string{module.Filename}
-	for dep := range module.InPkgDeps {
-		srcs = append(srcs, dep.Filename)
+	var srcs []string
+	for _, src := range module.srcModules() {
+		srcs = append(srcs, src.Filename)
 	}
 	sort.Strings(srcs)
 	rule.SetAttr("srcs", srcs)
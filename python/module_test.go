@@ -27,7 +27,8 @@ func TestModuleInPkgDeps(t *testing.T) {
 	moduleMap := map[string]*Module{
 		"pkg1.pkg2": {
 			Result: parser.Result{
-				Imports: []string{"pkg1", "pkg1.pkg2.subpkg1", "pkg1.pkg2.mod1"},
+				Imports: []parser.Import{{Name: "pkg1"}, {Name: "pkg1.pkg2.subpkg1"}, {Name: "pkg1.pkg2.mod1"}},
+				Symbols: []string{"sym1"},
 			},
 			ImportSpec: "pkg1.pkg2",
 			PkgPath:    "pkg1/pkg2",
@@ -35,7 +36,7 @@ func TestModuleInPkgDeps(t *testing.T) {
 		},
 		"pkg1.pkg2.mod1": {
 			Result: parser.Result{
-				Imports: []string{"pkg1", "pkg1.pkg2.subpkg1", "pkg1.pkg2.mod2", "pkg1.pkg2.sym1", "pkg1.pkg2.mod2.sym2"},
+				Imports: []parser.Import{{Name: "pkg1"}, {Name: "pkg1.pkg2.subpkg1"}, {Name: "pkg1.pkg2.mod2"}, {Name: "pkg1.pkg2.sym1"}, {Name: "pkg1.pkg2.mod2.sym2"}},
 			},
 			ImportSpec: "pkg1.pkg2.mod1",
 			PkgPath:    "pkg1/pkg2",
@@ -43,7 +44,8 @@ func TestModuleInPkgDeps(t *testing.T) {
 		},
 		"pkg1.pkg2.mod2": {
 			Result: parser.Result{
-				Imports: []string{"pkg1.pkg2.subpkg2"},
+				Imports: []parser.Import{{Name: "pkg1.pkg2.subpkg2"}},
+				Symbols: []string{"sym2"},
 			},
 			ImportSpec: "pkg1.pkg2.mod2",
 			PkgPath:    "pkg1/pkg2",
@@ -55,19 +57,16 @@ func TestModuleInPkgDeps(t *testing.T) {
 		"subpkg2": {},
 	}
 	testCases := []struct {
-		module                  *Module
-		wantInPkgImports        map[*Module]struct{}
-		wantInPkgImportsClosure map[*Module]struct{}
+		module           *Module
+		wantInPkgImports map[*Module]struct{}
+		wantCycle        []*Module
 	}{
 		{
 			module: moduleMap["pkg1.pkg2"],
 			wantInPkgImports: map[*Module]struct{}{
 				moduleMap["pkg1.pkg2.mod1"]: {},
 			},
-			wantInPkgImportsClosure: map[*Module]struct{}{
-				moduleMap["pkg1.pkg2.mod1"]: {},
-				moduleMap["pkg1.pkg2.mod2"]: {},
-			},
+			wantCycle: []*Module{moduleMap["pkg1.pkg2"], moduleMap["pkg1.pkg2.mod1"], moduleMap["pkg1.pkg2.mod2"]},
 		},
 		{
 			module: moduleMap["pkg1.pkg2.mod1"],
@@ -75,15 +74,13 @@ func TestModuleInPkgDeps(t *testing.T) {
 				moduleMap["pkg1.pkg2"]:      {},
 				moduleMap["pkg1.pkg2.mod2"]: {},
 			},
-			wantInPkgImportsClosure: map[*Module]struct{}{
-				moduleMap["pkg1.pkg2"]:      {},
-				moduleMap["pkg1.pkg2.mod2"]: {},
-			},
+			wantCycle: []*Module{moduleMap["pkg1.pkg2"], moduleMap["pkg1.pkg2.mod1"], moduleMap["pkg1.pkg2.mod2"]},
 		},
 		{
-			module:                  moduleMap["pkg1.pkg2.mod2"],
-			wantInPkgImports:        map[*Module]struct{}{},
-			wantInPkgImportsClosure: map[*Module]struct{}{},
+			// Depends on the package __init__.py, which imports it indirectly.
+			module:           moduleMap["pkg1.pkg2.mod2"],
+			wantInPkgImports: map[*Module]struct{}{},
+			wantCycle:        []*Module{moduleMap["pkg1.pkg2"], moduleMap["pkg1.pkg2.mod1"], moduleMap["pkg1.pkg2.mod2"]},
 		},
 	}
 
@@ -96,16 +93,99 @@ func TestModuleInPkgDeps(t *testing.T) {
 			t.Errorf("test %d: (-got, +want):%s", i, diff)
 		}
 	}
+	ComputeCycles([]*Module{moduleMap["pkg1.pkg2"], moduleMap["pkg1.pkg2.mod1"], moduleMap["pkg1.pkg2.mod2"]})
 	for i, testCase := range testCases {
-		module := testCase.module
-		module.DepsClosure()
-		got := testCase.module.InPkgDeps
-		if diff := cmp.Diff(got, testCase.wantInPkgImportsClosure); diff != "" {
+		var got, want []string
+		for _, member := range testCase.module.Cycle {
+			got = append(got, member.ImportSpec)
+		}
+		for _, member := range testCase.wantCycle {
+			want = append(want, member.ImportSpec)
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
 			t.Errorf("test %d: (-got, +want):%s", i, diff)
 		}
 	}
 }
 
+func TestModuleRelativeImports(t *testing.T) {
+	mod1 := &Module{
+		Result: parser.Result{
+			Imports: []parser.Import{
+				{Name: "mod2", Level: 1},
+				{Name: "mod2.sym1", Level: 1},
+				{Name: "mod2.undefined", Level: 1},
+				{Name: "subpkg1", Level: 1},
+				{Name: "sym2", Level: 2},
+				{Name: "pkg3.mod3", Level: 2},
+				{Name: "pkg4", Level: 3},
+				{Name: "mod3", Level: 1, Ignore: true},
+				{Name: "mod2.sym2", Level: 1, Deps: []string{":csv"}},
+			},
+			TypeOnlyImports: []parser.Import{
+				{Name: "mod2", Level: 1},
+				{Name: "typing_mod", Level: 2},
+				{Name: "ignored_mod", Level: 2, Ignore: true},
+			},
+		},
+		ImportSpec: "pkg1.pkg2.mod1",
+		PkgPath:    "pkg1/pkg2",
+		Name:       "mod1",
+		InPkgDeps:  make(map[*Module]struct{}),
+	}
+	mod2 := &Module{
+		Result: parser.Result{
+			Symbols: []string{"sym1"},
+		},
+		ImportSpec: "pkg1.pkg2.mod2",
+		PkgPath:    "pkg1/pkg2",
+		Name:       "mod2",
+		InPkgDeps:  make(map[*Module]struct{}),
+	}
+	moduleMap := map[string]*Module{
+		"pkg1.pkg2.mod1": mod1,
+		"pkg1.pkg2.mod2": mod2,
+	}
+	subPackages := map[string]struct{}{
+		"subpkg1": {},
+	}
+
+	mod1.ProcessImports(moduleMap, subPackages)
+	if diff := cmp.Diff(mod1.InPkgDeps, map[*Module]struct{}{mod2: {}}); diff != "" {
+		t.Errorf("InPkgDeps: (-got, +want):%s", diff)
+	}
+	wantExPkgImports := []parser.Import{{Name: "pkg1.pkg2.mod2.undefined"}, {Name: "pkg1.pkg2.subpkg1"}, {Name: "pkg1.sym2"}, {Name: "pkg1.pkg3.mod3"}, {Name: "pkg1.pkg2.mod2.sym2", Deps: []string{":csv"}}}
+	if diff := cmp.Diff(mod1.ExPkgImports, wantExPkgImports); diff != "" {
+		t.Errorf("ExPkgImports: (-got, +want):%s", diff)
+	}
+	wantTypeOnlyImports := []parser.Import{{Name: "pkg1.pkg2.mod2"}, {Name: "pkg1.typing_mod"}}
+	if diff := cmp.Diff(mod1.AbsTypeOnlyImports, wantTypeOnlyImports); diff != "" {
+		t.Errorf("AbsTypeOnlyImports: (-got, +want):%s", diff)
+	}
+}
+
+func TestModuleDefinesSymbol(t *testing.T) {
+	testCases := []struct {
+		symbols []string
+		name    string
+		want    bool
+	}{
+		{nil, "foo", false},
+		{nil, "*", true},
+		{[]string{"bar", "foo"}, "foo", true},
+		{[]string{"bar", "foo"}, "baz", false},
+		{[]string{"*", "bar"}, "baz", true},
+		{[]string{"__getattr__"}, "baz", true},
+	}
+
+	for i, testCase := range testCases {
+		module := &Module{Result: parser.Result{Symbols: testCase.symbols}}
+		if got := module.DefinesSymbol(testCase.name); got != testCase.want {
+			t.Errorf("test %d: got %t, want %t", i, got, testCase.want)
+		}
+	}
+}
+
 func TestGenerateRule(t *testing.T) {
 	testCases := []struct {
 		module        Module
@@ -195,6 +275,7 @@ func TestGenerateRule(t *testing.T) {
 				Name:      "__test__",
 				PkgPath:   "pkg1",
 				Filename:  "__test__.py",
+				IsTest:    true,
 				InPkgDeps: map[*Module]struct{}{},
 			},
 			nameTemplate:  "{module_name}",
@@ -240,7 +321,7 @@ func TestGenerateRule(t *testing.T) {
 			relPythonRoot: "..",
 			want: func() *rule.Rule {
 				r := rule.NewRule(kindPyLibrary, "bar")
-				r.SetAttr("srcs", []string{"bar.py", "baz.py"})
+				r.SetAttr("srcs", []string{"bar.py"})
 				r.SetAttr("imports", "..")
 				r.SetAttr("tags", []string{tagGazelleManaged})
 				r.SetAttr("visibility", []string{visibilityPublic})
@@ -276,6 +357,7 @@ func TestGenerateRule(t *testing.T) {
 				Name:     "foo_test",
 				PkgPath:  "pkg1",
 				Filename: "foo_test.py",
+				IsTest:   true,
 				InPkgDeps: map[*Module]struct{}{
 					{Filename: "foo.py"}: {},
 				},
@@ -284,7 +366,7 @@ func TestGenerateRule(t *testing.T) {
 			relPythonRoot: "..",
 			want: func() *rule.Rule {
 				r := rule.NewRule(kindPyTest, "foo_test")
-				r.SetAttr("srcs", []string{"foo.py", "foo_test.py"})
+				r.SetAttr("srcs", []string{"foo_test.py"})
 				r.SetAttr("imports", "..")
 				r.SetAttr("tags", []string{tagGazelleManaged})
 				return r
@@ -309,6 +391,26 @@ func TestGenerateRule(t *testing.T) {
 				return r
 			}(),
 		},
+		// Kind given by a pragma, for a module with a main name check.
+		{
+			module: Module{
+				Result:    parser.Result{HasMainNameCheck: true, RuleKind: kindPyLibrary},
+				Name:      "cli",
+				PkgPath:   "pkg1",
+				Filename:  "cli.py",
+				InPkgDeps: map[*Module]struct{}{},
+			},
+			nameTemplate:  "{module_name}",
+			relPythonRoot: "..",
+			want: func() *rule.Rule {
+				r := rule.NewRule(kindPyLibrary, "cli")
+				r.SetAttr("srcs", []string{"cli.py"})
+				r.SetAttr("imports", "..")
+				r.SetAttr("tags", []string{tagGazelleManaged})
+				r.SetAttr("visibility", []string{visibilityPublic})
+				return r
+			}(),
+		},
 	}
 
 	for _, testCase := range testCases {
@@ -341,3 +443,75 @@ func TestGenerateRule(t *testing.T) {
 		}
 	}
 }
+
+func TestComputeCyclesPackageInit(t *testing.T) {
+	pkg := &Module{Name: "", PkgPath: "pkg1", ImportSpec: "pkg1", Filename: "__init__.py"}
+	foo := &Module{Name: "foo", PkgPath: "pkg1", ImportSpec: "pkg1.foo", Filename: "foo.py"}
+	bar := &Module{Name: "bar", PkgPath: "pkg1", ImportSpec: "pkg1.bar", Filename: "bar.py"}
+	pkg.InPkgDeps = map[*Module]struct{}{foo: {}}
+	foo.InPkgDeps = map[*Module]struct{}{}
+	bar.InPkgDeps = map[*Module]struct{}{foo: {}}
+	ComputeCycles([]*Module{bar, foo, pkg})
+
+	testCases := []struct {
+		module    *Module
+		wantCycle []string
+	}{
+		// __init__.py imports foo, which depends on the package.
+		{pkg, []string{"pkg1", "pkg1.foo"}},
+		{foo, []string{"pkg1", "pkg1.foo"}},
+		// Depends on the package, which does not import it.
+		{bar, []string{"pkg1.bar"}},
+	}
+	for _, testCase := range testCases {
+		var got []string
+		for _, member := range testCase.module.Cycle {
+			got = append(got, member.ImportSpec)
+		}
+		if diff := cmp.Diff(got, testCase.wantCycle); diff != "" {
+			t.Errorf("test %s: (-got, +want):%s", testCase.module.ImportSpec, diff)
+		}
+	}
+	if owner := foo.ruleOwner(); owner != pkg {
+		t.Errorf("got rule owner %q for %q, want %q", owner.ImportSpec, foo.ImportSpec, pkg.ImportSpec)
+	}
+}
+
+func TestGenerateRuleCycles(t *testing.T) {
+	a := &Module{Name: "a", PkgPath: "pkg1", ImportSpec: "pkg1.a", Filename: "a.py"}
+	b := &Module{Name: "b", PkgPath: "pkg1", ImportSpec: "pkg1.b", Filename: "b.py"}
+	c := &Module{Name: "test_c", PkgPath: "pkg1", ImportSpec: "pkg1.test_c", Filename: "test_c.py", IsTest: true}
+	a.InPkgDeps = map[*Module]struct{}{b: {}}
+	b.InPkgDeps = map[*Module]struct{}{c: {}}
+	c.InPkgDeps = map[*Module]struct{}{a: {}}
+	ComputeCycles([]*Module{c, b, a})
+
+	testCases := []struct {
+		module   *Module
+		wantKind string
+		wantSrcs []string
+	}{
+		// Owner of the cycle.
+		{a, kindPyLibrary, []string{"a.py", "b.py", "test_c.py"}},
+		// Library in the cycle; no rule.
+		{b, "", nil},
+		// Test in the cycle; own rule.
+		{c, kindPyTest, []string{"test_c.py"}},
+	}
+
+	for _, testCase := range testCases {
+		got := testCase.module.GenerateRule("{module_name}", "..")
+		if got == nil {
+			if testCase.wantKind != "" {
+				t.Errorf("test %s: unexpected nil rule", testCase.module.Name)
+			}
+			continue
+		}
+		if diff := cmp.Diff(got.Kind(), testCase.wantKind); diff != "" {
+			t.Errorf("test %s: (-got, +want):%s", testCase.module.Name, diff)
+		}
+		if diff := cmp.Diff(got.AttrStrings("srcs"), testCase.wantSrcs); diff != "" {
+			t.Errorf("test %s: (-got, +want):%s", testCase.module.Name, diff)
+		}
+	}
+}
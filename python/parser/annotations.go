@@ -0,0 +1,158 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package parser
+
+import "strings"
+
+// Comments starting with "gazelle:" annotate the imports of the statement
+// which they trail or precede, e.g.
+//
+//	import foo  # gazelle:ignore
+//
+//	# gazelle:dep //plugins:csv
+//	import plugin
+//
+// In a statement spanning several lines, a trailing comment on a line with
+// imports, and comments on the lines preceding it, only annotate the imports
+// on that line. Comments on their own lines may also have pragmas for the
+// whole module, e.g. `# gazelle:py_kind py_binary`. Annotations may follow
+// other text in a comment, e.g. `# noqa: F401  # gazelle:ignore`; unknown
+// annotations are ignored.
+
+const annotationPrefix = "gazelle:"
+
+// Keys of the annotations of imports, and of the pragmas for the module.
+const (
+	annotationIgnore     = "ignore"
+	annotationDep        = "dep"
+	annotationKind       = "py_kind"
+	annotationIgnoreFile = "py_ignore_file"
+)
+
+// annotation is a `gazelle:key value` annotation in a comment.
+type annotation struct {
+	key   string
+	value string
+}
+
+// Returns the annotations in the comment.
+func (c comment) annotations() []annotation {
+	var res []annotation
+	for _, part := range strings.Split(c.text, "#") {
+		part = strings.TrimSpace(part)
+		if !strings.HasPrefix(part, annotationPrefix) {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(part, annotationPrefix))
+		if len(fields) == 0 {
+			continue
+		}
+		res = append(res, annotation{key: fields[0], value: strings.Join(fields[1:], " ")})
+	}
+	return res
+}
+
+// Sets the pragmas for the module from the comments on their own lines.
+func (res *Result) setPragmas(comments []comment) {
+	for _, c := range comments {
+		if !c.ownLine {
+			continue
+		}
+		for _, ann := range c.annotations() {
+			switch ann.key {
+			case annotationKind:
+				res.RuleKind = ann.value
+			case annotationIgnoreFile:
+				res.IgnoreFile = true
+			}
+		}
+	}
+}
+
+// Annotates the imports of a statement with the annotations in the comments
+// preceding the statement, and trailing any of its lines, as described above.
+func (v *visitor) annotateImports(imports []Import, toks []token) {
+	if len(v.comments) == 0 {
+		return
+	}
+	first, last := toks[0].line, toks[len(toks)-1].line
+	importLines := make(map[int]bool)
+	for _, imp := range imports {
+		importLines[imp.Line] = true
+	}
+	// Annotations of all the imports of the statement.
+	all := v.precedingAnnotations(first)
+	for line := first; line <= last; line++ {
+		if c, ok := v.comments[line]; ok && !c.ownLine && !importLines[line] {
+			all = append(all, c.annotations()...)
+		}
+	}
+	for i := range imports {
+		imp := &imports[i]
+		for _, ann := range all {
+			imp.annotate(ann)
+		}
+		if imp.Line != first {
+			for _, ann := range v.precedingAnnotations(imp.Line) {
+				imp.annotate(ann)
+			}
+		}
+		if c, ok := v.comments[imp.Line]; ok && !c.ownLine {
+			for _, ann := range c.annotations() {
+				imp.annotate(ann)
+			}
+		}
+	}
+}
+
+// Returns the annotations in the comments on their own lines immediately
+// preceding the line.
+func (v *visitor) precedingAnnotations(line int) []annotation {
+	var res []annotation
+	for l := line - 1; ; l-- {
+		c, ok := v.comments[l]
+		if !ok || !c.ownLine {
+			return res
+		}
+		res = append(res, c.annotations()...)
+	}
+}
+
+// Applies the annotation to the import, if it is an annotation of imports.
+func (imp *Import) annotate(ann annotation) {
+	switch ann.key {
+	case annotationIgnore:
+		imp.Ignore = true
+	case annotationDep:
+		imp.addDeps(strings.Fields(ann.value)...)
+	}
+}
+
+// Adds the labels to the dependencies of the import, keeping the order of the
+// first annotation of each.
+func (imp *Import) addDeps(deps ...string) {
+	for _, dep := range deps {
+		found := false
+		for _, prev := range imp.Deps {
+			if prev == dep {
+				found = true
+				break
+			}
+		}
+		if !found {
+			imp.Deps = append(imp.Deps, dep)
+		}
+	}
+}
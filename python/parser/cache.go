@@ -0,0 +1,207 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package parser
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Version of the parser, to be incremented whenever the Result for the same
+// source may change, e.g. when fields are added to Result. Cached results from
+// other versions are not used.
+const Version = 6
+
+// Entries in the cache not used for this long are removed.
+const cacheMaxAge = 30 * 24 * time.Hour
+
+// Entries are marked as used at most once in this interval, to save writes.
+const cacheTouchInterval = 24 * time.Hour
+
+// Subdirectory of the configured directory which holds the cache, so that
+// nothing else in the configured directory is ever removed.
+const cacheSubdir = "gazelle-python-parse-cache"
+
+// File written in the directory of each parser version when it is created, so
+// that only directories created by the cache are evicted.
+const cacheMarker = ".gazelle-python-parse-cache"
+
+var (
+	// Names of the directories of parser versions.
+	cacheVersionDirPattern = regexp.MustCompile(`^v[0-9]+$`)
+	// Names of entries, and of temporary files left by interrupted writes.
+	cacheEntryPattern = regexp.MustCompile(`^([0-9a-f]{64}\.json|\.tmp-[0-9]+)$`)
+)
+
+// Cache is an on-disk cache of parse results, keyed by the hash of the source
+// contents. Entries for each parser version are kept in their own
+// subdirectory, and are written atomically, so that the cache can be shared
+// by concurrent goroutines and processes.
+//
+// A nil *Cache parses without caching.
+type Cache struct {
+	// Directory for entries of this parser version.
+	dir string
+}
+
+// NewCache returns a cache in a subdirectory of the given directory, creating
+// it if needed. Entries from other parser versions, and entries not used
+// recently, are removed; nothing else in the directory is touched.
+func NewCache(dir string) (*Cache, error) {
+	root := filepath.Join(dir, cacheSubdir)
+	c := &Cache{dir: filepath.Join(root, "v"+strconv.Itoa(Version))}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating Python parse cache: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(c.dir, cacheMarker), nil, 0o644); err != nil {
+		return nil, fmt.Errorf("creating Python parse cache: %w", err)
+	}
+	if err := c.evict(root, time.Now().Add(-cacheMaxAge)); err != nil {
+		return nil, fmt.Errorf("evicting stale entries from Python parse cache: %w", err)
+	}
+	return c, nil
+}
+
+// Removes the directories for other parser versions in root, which have the
+// marker file of the cache, and the entries last used before the cutoff.
+func (c *Cache) evict(root string, cutoff time.Time) error {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		p := filepath.Join(root, entry.Name())
+		if p == c.dir || !entry.IsDir() || !cacheVersionDirPattern.MatchString(entry.Name()) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(p, cacheMarker)); err != nil {
+			continue
+		}
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+	return filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// Removed concurrently.
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !cacheEntryPattern.MatchString(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if info.ModTime().Before(cutoff) {
+			if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ParsePath parses a Python module at the given path, using the cached result
+// for the same contents if available.
+func (c *Cache) ParsePath(path string) (Result, error) {
+	if c == nil {
+		return ParsePath(path)
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return Result{}, fmt.Errorf("opening Python file: %q: %w", path, err)
+	}
+	sum := sha256.Sum256(src)
+	key := hex.EncodeToString(sum[:])
+	entryPath := filepath.Join(c.dir, key[:2], key+".json")
+	if res, ok := c.read(entryPath); ok {
+		return res, nil
+	}
+	res, err := Parse(bytes.NewReader(src), path)
+	if err != nil {
+		return res, fmt.Errorf("parsing Python file: %q: %w", path, err)
+	}
+	if err := c.write(entryPath, res); err != nil {
+		log.Printf("unable to write to Python parse cache: %v", err)
+	}
+	return res, nil
+}
+
+// Reads the entry, and marks it as used if not done recently.
+func (c *Cache) read(entryPath string) (Result, bool) {
+	f, err := os.Open(entryPath)
+	if err != nil {
+		return Result{}, false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return Result{}, false
+	}
+	var res Result
+	if err := json.NewDecoder(f).Decode(&res); err != nil {
+		// Corrupted entries are replaced.
+		return Result{}, false
+	}
+	if now := time.Now(); now.Sub(info.ModTime()) > cacheTouchInterval {
+		_ = os.Chtimes(entryPath, now, now)
+	}
+	return res, true
+}
+
+// Writes the entry to a temporary file, which is then renamed, so that readers
+// never see a partial entry.
+func (c *Cache) write(entryPath string, res Result) error {
+	content, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(entryPath), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(entryPath), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), entryPath); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package parser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	src := []byte("import os\nfrom . import sibling\n\ndef main():\n    pass\n")
+	path := filepath.Join(dir, "mod.py")
+	if err := os.WriteFile(path, src, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want, err := ParsePath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewCache(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Concurrent misses and hits for the same contents.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := c.ParsePath(path)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("(-got, +want):%s", diff)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The entry is used instead of parsing again.
+	sum := sha256.Sum256(src)
+	key := hex.EncodeToString(sum[:])
+	entryPath := filepath.Join(cacheDir, cacheSubdir, "v"+strconv.Itoa(Version), key[:2], key+".json")
+	if err := os.WriteFile(entryPath, []byte(`{"Symbols":["cached"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.ParsePath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, Result{Symbols: []string{"cached"}}); diff != "" {
+		t.Errorf("cached: (-got, +want):%s", diff)
+	}
+
+	// Corrupted entries are replaced.
+	if err := os.WriteFile(entryPath, []byte(`{`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = c.ParsePath(path); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("corrupted: (-got, +want):%s", diff)
+	}
+
+	// Errors are not cached.
+	if _, err := c.ParsePath(filepath.Join(dir, "missing.py")); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	cacheDir := t.TempDir()
+	root := filepath.Join(cacheDir, cacheSubdir)
+	versionDir := filepath.Join(root, "v"+strconv.Itoa(Version))
+	staleVersion := filepath.Join(root, "v0", "ab", "abcd.json")
+	staleVersionMarker := filepath.Join(root, "v0", cacheMarker)
+	stale := filepath.Join(versionDir, "ab", strings.Repeat("ab", 32)+".json")
+	fresh := filepath.Join(versionDir, "ab", strings.Repeat("ac", 32)+".json")
+	// Files which were not created by the cache, e.g. with the cache in the
+	// workspace or a shared directory, must survive.
+	foreign := []string{
+		filepath.Join(cacheDir, "important.txt"),
+		filepath.Join(cacheDir, "v1", "src", "mod.py"),
+		filepath.Join(cacheDir, "src", "mod.py"),
+		filepath.Join(root, "v2", "notes.txt"),
+		filepath.Join(root, "other", "notes.txt"),
+		filepath.Join(versionDir, "ab", "notes.txt"),
+	}
+	for _, p := range append([]string{staleVersion, staleVersionMarker, stale, fresh}, foreign...) {
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("{}"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * cacheMaxAge)
+	for _, p := range append([]string{stale}, foreign...) {
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := NewCache(cacheDir); err != nil {
+		t.Fatal(err)
+	}
+	wantExists := map[string]bool{staleVersion: false, stale: false, fresh: true}
+	for _, p := range foreign {
+		wantExists[p] = true
+	}
+	for p, wantExists := range wantExists {
+		if _, err := os.Stat(p); (err == nil) != wantExists {
+			t.Errorf("%s: got exists %t, want %t", p, err == nil, wantExists)
+		}
+	}
+}
+
+func TestNilCache(t *testing.T) {
+	var c *Cache
+	path := filepath.Join("testdata", "misc.py")
+	got, err := c.ParsePath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ParsePath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("(-got, +want):%s", diff)
+	}
+}
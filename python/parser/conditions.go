@@ -0,0 +1,140 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Imports under an if statement testing the Python version or the platform
+// are recorded with the condition, in a canonical form: tokens separated as
+// formatted by black, and strings in double quotes, e.g.
+// `sys.version_info >= (3, 11)` or `not sys.platform.startswith("linux")`. The
+// else branch has the negated condition, and nested conditions are joined by
+// " and ".
+
+// Attributes on which conditions are recognized, by the module which must be
+// imported.
+var conditionSubjects = [][]string{
+	{"sys", "version_info"},
+	{"sys", "platform"},
+	{"os", "name"},
+}
+
+// Comparison operators, and other tokens surrounded by spaces in the canonical
+// form of a condition.
+var conditionSpacedOps = map[string]bool{
+	"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true,
+	"in": true, "not": true, "is": true,
+}
+
+// NormalizeCondition returns the canonical form of a condition on the Python
+// version or the platform, as recorded for imports, e.g.
+// `sys.version_info >= (3, 11)` for `sys.version_info>=(3,11)`.
+func NormalizeCondition(expr string) (string, error) {
+	toks, err := tokenize(strings.TrimSpace(expr))
+	if err != nil {
+		return "", err
+	}
+	// Drop the trailing NEWLINE and EOF.
+	for len(toks) > 0 && (toks[len(toks)-1].kind == tokenNewline || toks[len(toks)-1].kind == tokenEOF) {
+		toks = toks[:len(toks)-1]
+	}
+	cond, _, ok := parseCondition(toks)
+	if !ok {
+		return "", fmt.Errorf("expected a condition on sys.version_info, sys.platform or os.name: %q", expr)
+	}
+	return cond, nil
+}
+
+// Returns the canonical form of the test of an if statement, if it is a
+// condition on the Python version or the platform, and the module of the
+// attribute tested, e.g. "sys".
+func parseCondition(test []token) (string, string, bool) {
+	negated := false
+	for len(test) > 0 && test[0].is(tokenName, "not") {
+		negated = !negated
+		test = test[1:]
+	}
+	var module string
+	for _, subject := range conditionSubjects {
+		if len(test) >= 3 && test[0].is(tokenName, subject[0]) && test[1].is(tokenOp, ".") && test[2].is(tokenName, subject[1]) {
+			module = subject[0]
+			break
+		}
+	}
+	if module == "" {
+		return "", "", false
+	}
+	for _, tok := range test {
+		switch {
+		case tok.kind == tokenName && (tok.text == "and" || tok.text == "or" || tok.text == "if" || tok.text == "lambda"):
+			return "", "", false
+		case tok.is(tokenOp, ":="):
+			return "", "", false
+		}
+	}
+	cond, ok := formatCondition(test)
+	if !ok {
+		return "", "", false
+	}
+	if negated {
+		cond = negateCondition(cond)
+	}
+	return cond, module, true
+}
+
+// Formats the tokens of a condition in the canonical form.
+func formatCondition(toks []token) (string, bool) {
+	var b strings.Builder
+	space := false // Whether a space is due before the next token.
+	for _, tok := range toks {
+		text := tok.text
+		before, after := false, false
+		switch {
+		case tok.kind == tokenString:
+			value, ok := stringValue(tok)
+			if !ok {
+				return "", false
+			}
+			text = strconv.Quote(value)
+		case tok.kind == tokenName || tok.kind == tokenOp:
+			before = conditionSpacedOps[text]
+			after = before || text == ","
+		}
+		closing := tok.is(tokenOp, ")") || tok.is(tokenOp, "]")
+		if (space || before) && b.Len() > 0 && !closing {
+			b.WriteByte(' ')
+		}
+		b.WriteString(text)
+		space = after
+	}
+	return b.String(), true
+}
+
+// Returns the negation of a condition in the canonical form.
+func negateCondition(cond string) string {
+	if negated := strings.TrimPrefix(cond, "not "); negated != cond && !strings.Contains(negated, " and ") {
+		return negated
+	}
+	return "not " + cond
+}
+
+// Returns the condition of the statements being visited; empty if none.
+func (v *visitor) condition() string {
+	return strings.Join(v.conditions, " and ")
+}
@@ -0,0 +1,230 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package parser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// dynamicImportFunc describes a function which imports or finds a module given
+// by name at run time.
+type dynamicImportFunc struct {
+	// Whether a relative name is anchored to the package given by the second
+	// positional argument, or the package keyword argument.
+	packageArg bool
+	// Whether the name may be followed by ":" and the name of an object in
+	// the module, as for pkgutil.resolve_name.
+	objectSuffix bool
+}
+
+// Functions which import modules dynamically, by their qualified names.
+var dynamicImportFuncs = map[string]dynamicImportFunc{
+	"__import__":               {},
+	"importlib.__import__":     {},
+	"importlib.import_module":  {packageArg: true},
+	"importlib.util.find_spec": {packageArg: true},
+	"pkgutil.find_loader":      {},
+	"pkgutil.get_loader":       {},
+	"pkgutil.resolve_name":     {objectSuffix: true},
+}
+
+// dynamicCall is a call to a dynamic import function, with the callee as
+// written in the source, e.g. "import_module" or "importlib.import_module".
+type dynamicCall struct {
+	callee string
+	fn     string // Qualified name of the function.
+	imp    Import
+}
+
+// Collects the calls to dynamic import functions with literal arguments in the
+// tokens of a statement.
+func (v *visitor) collectDynamicCalls(toks []token) {
+	if v.typeOnlyDepth > 0 {
+		return
+	}
+	for i := 0; i < len(toks); i++ {
+		if toks[i].kind != tokenName || (i > 0 && toks[i-1].is(tokenOp, ".")) {
+			continue
+		}
+		parts := []string{toks[i].text}
+		j := i + 1
+		for j+1 < len(toks) && toks[j].is(tokenOp, ".") && toks[j+1].kind == tokenName {
+			parts = append(parts, toks[j+1].text)
+			j += 2
+		}
+		if j >= len(toks) || !toks[j].is(tokenOp, "(") {
+			continue
+		}
+		callee := strings.Join(parts, ".")
+		fn, ok := dynamicImportFuncName(callee)
+		if !ok {
+			continue
+		}
+		// Calls nested in the arguments are found as the scan continues.
+		if imp, ok := dynamicImport(dynamicImportFuncs[fn], callArgs(toks, j)); ok {
+			imp.Optional = v.optionalDepth > 0
+			imp.Condition = v.condition()
+			imports := []Import{imp}
+			v.annotateImports(imports, toks)
+			v.dynamicCalls = append(v.dynamicCalls, dynamicCall{callee: callee, fn: fn, imp: imports[0]})
+		}
+	}
+}
+
+// Returns the qualified name of the dynamic import function which may be
+// called by the callee, as written in the source.
+func dynamicImportFuncName(callee string) (string, bool) {
+	if _, ok := dynamicImportFuncs[callee]; ok {
+		return callee, true
+	}
+	for fn := range dynamicImportFuncs {
+		if strings.HasSuffix(fn, "."+callee) {
+			return fn, true
+		}
+	}
+	return "", false
+}
+
+// Returns true if the callee refers to the function given the static imports
+// in the module, e.g. "importlib.import_module" after `import importlib`, or
+// "import_module" after `from importlib import import_module`. Aliases are not
+// followed.
+func (v *visitor) isDynamicImportCallee(call dynamicCall) bool {
+	if call.callee == "__import__" {
+		return true
+	}
+	if call.callee != call.fn {
+		// Imported from its module, or a submodule, e.g. "util.find_spec"
+		// after `from importlib import util`.
+		first, _, _ := strings.Cut(call.callee, ".")
+		_, ok := v.imported[importKey{name: strings.TrimSuffix(call.fn, "."+call.callee) + "." + first}]
+		return ok
+	}
+	for prefix := call.fn; strings.Contains(prefix, "."); {
+		prefix = prefix[:strings.LastIndexByte(prefix, '.')]
+		if _, ok := v.imported[importKey{name: prefix}]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the arguments of the call with the opening parenthesis at index
+// open, split at top-level commas.
+func callArgs(toks []token, open int) [][]token {
+	var args [][]token
+	depth, start := 0, open+1
+	for i := open; i < len(toks); i++ {
+		switch {
+		case toks[i].is(tokenOp, "(") || toks[i].is(tokenOp, "[") || toks[i].is(tokenOp, "{"):
+			depth++
+		case toks[i].is(tokenOp, ")") || toks[i].is(tokenOp, "]") || toks[i].is(tokenOp, "}"):
+			depth--
+			if depth == 0 {
+				if i > start {
+					args = append(args, toks[start:i])
+				}
+				return args
+			}
+		case toks[i].is(tokenOp, ",") && depth == 1:
+			args = append(args, toks[start:i])
+			start = i + 1
+		}
+	}
+	return args
+}
+
+// Returns the import for a call to a dynamic import function with the
+// arguments, if the module name is a string literal. Names relative to the
+// package argument are made absolute if the package is a string literal, or
+// kept relative to the current package if it is __package__.
+func dynamicImport(fn dynamicImportFunc, args [][]token) (Import, bool) {
+	var name, pkg []token
+	level := 0
+	for i, arg := range args {
+		if len(arg) > 2 && arg[0].kind == tokenName && arg[1].is(tokenOp, "=") {
+			switch value := arg[2:]; arg[0].text {
+			case "name":
+				name = value
+			case "package":
+				pkg = value
+			case "level":
+				if len(value) == 1 && value[0].kind == tokenNumber {
+					level, _ = strconv.Atoi(value[0].text)
+				}
+			}
+			continue
+		}
+		switch {
+		case i == 0:
+			name = arg
+		case i == 1 && fn.packageArg:
+			pkg = arg
+		}
+	}
+	if len(name) != 1 {
+		return Import{}, false
+	}
+	value, ok := stringValue(name[0])
+	if !ok || value == "" {
+		return Import{}, false
+	}
+	if fn.objectSuffix {
+		value, _, _ = strings.Cut(value, ":")
+	}
+	imp := Import{Name: value, Level: level, Line: name[0].line, Dynamic: true}
+	if !fn.packageArg || !strings.HasPrefix(value, ".") {
+		return imp, isDottedName(imp.Name)
+	}
+	// A relative name, e.g. import_module(".b", package="a").
+	imp.Name = strings.TrimLeft(value, ".")
+	imp.Level = len(value) - len(imp.Name)
+	if len(pkg) != 1 {
+		return Import{}, false
+	}
+	if pkg[0].is(tokenName, "__package__") {
+		return imp, imp.Name == "" || isDottedName(imp.Name)
+	}
+	anchor, ok := stringValue(pkg[0])
+	if !ok || !isDottedName(anchor) {
+		return Import{}, false
+	}
+	parts := strings.Split(anchor, ".")
+	if imp.Level > len(parts) {
+		return Import{}, false
+	}
+	parts = parts[:len(parts)-imp.Level+1]
+	if imp.Name != "" {
+		parts = append(parts, imp.Name)
+	}
+	imp.Name, imp.Level = strings.Join(parts, "."), 0
+	return imp, isDottedName(imp.Name)
+}
+
+// Returns true if the value is a dot separated sequence of identifiers.
+func isDottedName(value string) bool {
+	for _, part := range strings.Split(value, ".") {
+		if part == "" || !isNameStart(part[0]) {
+			return false
+		}
+		for j := 1; j < len(part); j++ {
+			if !isNameChar(part[j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
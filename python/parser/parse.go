@@ -15,9 +15,11 @@
 // Package parser parses Python files and returns relevant information to the
 // Gazelle Python extension.
 //
-// It is currently using a Python AST parser written in Go, which implements the
-// spec from Python 3.4. If this is insufficient, this package will need to be
-// rewritten to farm out the processing to a pool of running Python processes.
+// It does not build a full syntax tree. Instead, it tokenizes the source and
+// parses only the statement structure of the module, i.e. the blocks of simple
+// and compound statements, and then the statements of interest, like imports,
+// in full. This keeps it tolerant of new syntax in expressions, and it
+// understands the syntax up to at least Python 3.13.
 package parser
 
 import (
@@ -25,17 +27,73 @@ import (
 	"io"
 	"os"
 	"sort"
-
-	"github.com/go-python/gpython/ast"
-	"github.com/go-python/gpython/parser"
-	"github.com/go-python/gpython/py"
+	"strings"
 )
 
-const debugParse = false
+// Import is a module, or a name from a module, imported by an import statement.
+type Import struct {
+	// Dot separated import specifier. For relative imports, this is relative
+	// to the package given by Level.
+	Name string
+	// Number of leading dots for relative imports; 0 for absolute imports.
+	Level int
+	// Line (1-based) of the first import of the module in the source.
+	Line int
+	// Whether the module is imported at run time by a call like
+	// importlib.import_module("a.b") with a literal name, rather than by an
+	// import statement.
+	Dynamic bool
+	// Whether all imports of the module are in the body of a try statement
+	// which handles ImportError, i.e. the module need not be available.
+	Optional bool
+	// Condition on the Python version or the platform under which the module
+	// is imported, in the canonical form given by NormalizeCondition; empty
+	// if imported unconditionally, or under different conditions.
+	Condition string
+	// Whether all imports of the module are annotated with
+	// `# gazelle:ignore`, i.e. the module is not a dependency.
+	Ignore bool
+	// Labels given by `# gazelle:dep` annotations of the imports of the
+	// module, which are the dependencies for the module instead of the rule
+	// which provides it.
+	Deps []string
+}
+
+// Key of an import, without the position in the source.
+type importKey struct {
+	name  string
+	level int
+}
+
+func (imp Import) key() importKey {
+	return importKey{name: imp.Name, level: imp.Level}
+}
+
+// String returns the import specifier as written in the source, i.e. with the
+// leading dots for relative imports.
+func (imp Import) String() string {
+	return strings.Repeat(".", imp.Level) + imp.Name
+}
 
 type Result struct {
-	Imports          []string
+	Imports []Import
+	// Imports only under `if TYPE_CHECKING:`, which are only needed by type
+	// checkers, e.g. for annotations which are not evaluated at run time as
+	// with `from __future__ import annotations`. Modules also imported at run
+	// time are only in Imports.
+	TypeOnlyImports []Import
+	// Sorted names defined at the top level of the module, i.e. functions,
+	// classes, assigned variables, names listed in __all__, and names bound by
+	// import statements. A wildcard import adds "*" as any name may then be
+	// re-exported from the imported module.
+	Symbols          []string
 	HasMainNameCheck bool
+	// Kind of the rule for the module given by a `# gazelle:py_kind` pragma;
+	// empty if none.
+	RuleKind string
+	// Whether the module has a `# gazelle:py_ignore_file` pragma, i.e. no
+	// rule is generated for it.
+	IgnoreFile bool
 }
 
 // ParsePath parses a Python module at the given path.
@@ -54,93 +112,712 @@ func ParsePath(path string) (Result, error) {
 
 // Parse parses a Python module read by the reader.
 func Parse(r io.Reader, filename string) (Result, error) {
-	res := Result{}
-	tree, err := parser.Parse(r, filename, py.ExecMode)
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return Result{}, err
+	}
+	toks, comments, err := tokenizeWithComments(string(src))
+	if err != nil {
+		return Result{}, err
+	}
+	p := &stmtParser{toks: toks}
+	block, err := p.parseBlock(false)
 	if err != nil {
 		return Result{}, err
 	}
-	if debugParse {
-		println(ast.Dump(tree))
+	v := &visitor{imported: make(map[importKey]Import), typeOnly: make(map[importKey]Import), symbolSet: make(map[string]struct{}), comments: make(map[int]comment)}
+	for _, c := range comments {
+		v.comments[c.line] = c
+	}
+	v.res.setPragmas(comments)
+	if err := v.visitBlock(block); err != nil {
+		return Result{}, err
+	}
+	res := v.res
+	// Dynamic imports are added once all the static imports are known, to
+	// check the imports of the functions called.
+	var dynamic []Import
+	for _, call := range v.dynamicCalls {
+		if v.isDynamicImportCallee(call) {
+			dynamic = append(dynamic, call.imp)
+		}
+	}
+	for _, imp := range dynamic {
+		v.addImport(imp)
+	}
+	for _, imp := range v.imported {
+		res.Imports = append(res.Imports, imp)
+	}
+	for key, imp := range v.typeOnly {
+		if _, ok := v.imported[key]; !ok {
+			res.TypeOnlyImports = append(res.TypeOnlyImports, imp)
+		}
 	}
-	importedSet := make(map[string]struct{})
-	ast.Walk(tree, func(node ast.Ast) bool { return visitor(node, importedSet, &res) })
-	for name := range importedSet {
-		res.Imports = append(res.Imports, name)
+	for symbol := range v.symbolSet {
+		res.Symbols = append(res.Symbols, symbol)
 	}
-	sort.Strings(res.Imports)
+	sort.Strings(res.Symbols)
+	sortImports(res.Imports)
+	sortImports(res.TypeOnlyImports)
 	return res, nil
 }
 
-// Checks for import statements and the `if __name__ == "__main__"` block.
-func visitor(tree ast.Ast, importedSet map[string]struct{}, res *Result) bool {
-	stmt, ok := tree.(ast.Stmt)
+// Sorts the imports by level and name.
+func sortImports(imports []Import) {
+	sort.Slice(imports, func(i, j int) bool {
+		if a, b := imports[i].Level, imports[j].Level; a != b {
+			return a < b
+		}
+		return imports[i].Name < imports[j].Name
+	})
+}
+
+// stmt is a statement in a block of statements.
+type stmt struct {
+	// Tokens of a simple statement, or of the header of a clause of a
+	// compound statement without the trailing colon.
+	toks []token
+	// Body of a compound statement clause; nil for simple statements.
+	body []*stmt
+	// Clauses continuing a compound statement, e.g. elif, else, except and
+	// finally.
+	clauses []*stmt
+}
+
+func (s *stmt) keyword() string {
+	if s.toks[0].kind != tokenName {
+		return ""
+	}
+	return s.toks[0].text
+}
+
+// stmtParser parses the tokens of a module into blocks of statements.
+type stmtParser struct {
+	toks []token
+	pos  int
+}
+
+// Parses statements until the end of the current block. In a match block, the
+// statements are case clauses.
+func (p *stmtParser) parseBlock(inMatch bool) ([]*stmt, error) {
+	var block []*stmt
+	for {
+		switch tok := p.toks[p.pos]; tok.kind {
+		case tokenEOF:
+			return block, nil
+		case tokenDedent:
+			p.pos++
+			return block, nil
+		case tokenIndent:
+			return nil, fmt.Errorf("line %d: unexpected indent", tok.line)
+		case tokenNewline:
+			p.pos++
+			continue
+		}
+		stmts, err := p.parseLine(inMatch)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range stmts {
+			switch s.keyword() {
+			case "elif", "else", "except", "finally":
+				if s.body != nil && len(block) > 0 && block[len(block)-1].body != nil {
+					last := block[len(block)-1]
+					last.clauses = append(last.clauses, s)
+					continue
+				}
+			}
+			block = append(block, s)
+		}
+	}
+}
+
+// Parses a logical line, and the block following it for compound statements.
+func (p *stmtParser) parseLine(inMatch bool) ([]*stmt, error) {
+	start := p.pos
+	for p.toks[p.pos].kind != tokenNewline {
+		p.pos++
+	}
+	line := p.toks[start:p.pos]
+	p.pos++
+
+	colon, ok := compoundHeader(line, inMatch)
 	if !ok {
-		// Let's be simple and try continuing the walk in all cases.
-		return true
+		return splitSimpleStmts(line), nil
+	}
+	s := &stmt{toks: line[:colon]}
+	if inline := line[colon+1:]; len(inline) > 0 {
+		s.body = splitSimpleStmts(inline)
+		return []*stmt{s}, nil
+	}
+	if p.toks[p.pos].kind != tokenIndent {
+		return nil, fmt.Errorf("line %d: expected an indented block", line[0].line)
+	}
+	p.pos++
+	var err error
+	s.body, err = p.parseBlock(line[0].is(tokenName, "match"))
+	if err != nil {
+		return nil, err
+	}
+	return []*stmt{s}, nil
+}
+
+// Returns the index of the colon ending the header of a compound statement,
+// if the logical line starts a compound statement.
+func compoundHeader(line []token, inMatch bool) (int, bool) {
+	if line[0].kind != tokenName {
+		return 0, false
+	}
+	switch line[0].text {
+	case "if", "elif", "else", "while", "for", "try", "except", "finally", "with", "def", "class", "async":
+	case "case":
+		if !inMatch {
+			return 0, false
+		}
+	case "match":
+		// Soft keyword; distinguish from statements using match as a name.
+		last := len(line) - 1
+		if last < 2 || !line[last].is(tokenOp, ":") {
+			return 0, false
+		}
+		if next := line[1]; next.kind == tokenOp {
+			switch next.text {
+			case "(", "[", "{", "-", "+", "*", "~", "...":
+			default:
+				return 0, false
+			}
+		}
+		return last, headerColon(line) == last
+	default:
+		return 0, false
 	}
-	switch stmt := stmt.(type) {
-	case *ast.Import:
-		for _, alias := range stmt.Names {
-			importedSet[string(alias.Name)] = struct{}{}
+	colon := headerColon(line)
+	return colon, colon > 0
+}
+
+// Returns the index of the first colon outside brackets and lambda
+// expressions, or -1.
+func headerColon(line []token) int {
+	depth, lambdas := 0, 0
+	for i, tok := range line {
+		if tok.kind == tokenName && tok.text == "lambda" && depth == 0 {
+			lambdas++
+			continue
 		}
-	case *ast.ImportFrom:
-		for _, alias := range stmt.Names {
-			importedSet[string(stmt.Module)+"."+string(alias.Name)] = struct{}{}
+		if tok.kind != tokenOp {
+			continue
 		}
-	case *ast.If:
-		if got, safeBody := isTypeCheckingConditional(importedSet, stmt); got {
-			for _, stmt := range safeBody {
-				ast.Walk(stmt, func(tree ast.Ast) bool { return visitor(tree, importedSet, res) })
+		switch tok.text {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+		case ":":
+			if depth > 0 {
+				continue
+			}
+			if lambdas > 0 {
+				lambdas--
+				continue
 			}
-			return false
+			return i
+		}
+	}
+	return -1
+}
+
+// Splits a sequence of simple statements separated by semicolons.
+func splitSimpleStmts(toks []token) []*stmt {
+	var res []*stmt
+	start := 0
+	for i := 0; i <= len(toks); i++ {
+		if i < len(toks) && !toks[i].is(tokenOp, ";") {
+			continue
+		}
+		if i > start {
+			res = append(res, &stmt{toks: toks[start:i]})
 		}
-		if !res.HasMainNameCheck && isMainNameCheck(stmt) {
-			res.HasMainNameCheck = true
+		start = i + 1
+	}
+	return res
+}
+
+// visitor collects the information in Result from the statements of a module.
+type visitor struct {
+	imported map[importKey]Import
+	// Imports in TYPE_CHECKING conditionals.
+	typeOnly  map[importKey]Import
+	symbolSet map[string]struct{}
+	// Depth of nested function and class bodies; symbols are only collected
+	// at the top level of the module.
+	scopeDepth int
+	// Depth of nested try bodies with handlers for ImportError, in which
+	// imports are optional.
+	optionalDepth int
+	// Depth of nested TYPE_CHECKING conditionals, in which imports are only
+	// for type checkers, and names are not bound at run time.
+	typeOnlyDepth int
+	// Conditions on the Python version or the platform of the enclosing if
+	// statements, in the canonical form.
+	conditions []string
+	// Calls which may import modules dynamically, in the order in the source.
+	dynamicCalls []dynamicCall
+	// Comments in the source by line, for annotations.
+	comments map[int]comment
+	res      Result
+}
+
+func (v *visitor) visitBlock(block []*stmt) error {
+	for _, s := range block {
+		if err := v.visitStmt(s); err != nil {
+			return err
 		}
 	}
-	return true
+	return nil
 }
 
-// If this is a typing.TYPE_CHECKING conditional, then do not discard the
-// positive branch and return the other.
+// Checks for import statements, top-level definitions and the
+// `if __name__ == "__main__"` block.
+func (v *visitor) visitStmt(s *stmt) error {
+	if s.body == nil {
+		switch s.keyword() {
+		case "import", "from":
+			imports, bound, err := parseImport(s.toks)
+			if err != nil {
+				return err
+			}
+			v.annotateImports(imports, s.toks)
+			for _, imp := range imports {
+				imp.Optional = v.optionalDepth > 0
+				imp.Condition = v.condition()
+				if v.typeOnlyDepth > 0 {
+					if _, ok := v.typeOnly[imp.key()]; !ok {
+						v.typeOnly[imp.key()] = imp
+					}
+					continue
+				}
+				v.addImport(imp)
+			}
+			v.addSymbols(bound...)
+		default:
+			v.addSymbols(assignedNames(s.toks)...)
+			v.collectDynamicCalls(s.toks)
+		}
+		return nil
+	}
+	if s.keyword() == "if" {
+		return v.visitIf(append([]*stmt{s}, s.clauses...))
+	}
+	v.collectDynamicCalls(s.toks)
+	switch s.keyword() {
+	case "def", "class", "async":
+		if name, ok := definedName(s.toks); ok {
+			v.addSymbols(name)
+			v.scopeDepth++
+			defer func() { v.scopeDepth-- }()
+		}
+	}
+	optional := s.keyword() == "try" && handlesImportError(s.clauses)
+	if optional {
+		v.optionalDepth++
+	}
+	err := v.visitBlock(s.body)
+	if optional {
+		v.optionalDepth--
+	}
+	if err != nil {
+		return err
+	}
+	for _, clause := range s.clauses {
+		if err := v.visitBlock(clause.body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Adds the import, keeping the first import of the module. The module is
+// optional only if all its imports are optional, conditional only if all its
+// imports are under the same condition, and ignored only if all its imports
+// are ignored.
+func (v *visitor) addImport(imp Import) {
+	prev, ok := v.imported[imp.key()]
+	if !ok {
+		v.imported[imp.key()] = imp
+		return
+	}
+	if !imp.Optional {
+		prev.Optional = false
+	}
+	if imp.Condition != prev.Condition {
+		prev.Condition = ""
+	}
+	if !imp.Ignore {
+		prev.Ignore = false
+	}
+	prev.addDeps(imp.Deps...)
+	v.imported[imp.key()] = prev
+}
+
+// Returns true if any of the except clauses of a try statement handles
+// ImportError or its subclass ModuleNotFoundError, including bare except
+// clauses.
+func handlesImportError(clauses []*stmt) bool {
+	for _, clause := range clauses {
+		if clause.keyword() != "except" {
+			continue
+		}
+		for _, tok := range clause.toks[1:] {
+			if tok.is(tokenName, "as") {
+				break
+			}
+			if tok.is(tokenName, "ImportError") || tok.is(tokenName, "ModuleNotFoundError") {
+				return true
+			}
+		}
+		if len(clause.toks) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *visitor) addSymbols(names ...string) {
+	if v.scopeDepth > 0 || v.typeOnlyDepth > 0 {
+		return
+	}
+	for _, name := range names {
+		v.symbolSet[name] = struct{}{}
+	}
+}
+
+// Visits a chain of if, elif and else clauses.
+func (v *visitor) visitIf(chain []*stmt) error {
+	clause, rest := chain[0], chain[1:]
+	if clause.keyword() == "else" {
+		return v.visitBlock(clause.body)
+	}
+	test := clause.toks[1:]
+	v.collectDynamicCalls(test)
+	if got, negated := v.isTypeCheckingConditional(test); got {
+		// Imports in the branch taken by type checkers are only for them.
+		positive := func() error { return v.visitBlock(clause.body) }
+		negative := func() error {
+			if len(rest) > 0 {
+				return v.visitIf(rest)
+			}
+			return nil
+		}
+		if negated {
+			positive, negative = negative, positive
+		}
+		v.typeOnlyDepth++
+		err := positive()
+		v.typeOnlyDepth--
+		if err != nil {
+			return err
+		}
+		return negative()
+	}
+	if !v.res.HasMainNameCheck && isMainNameCheck(test) {
+		v.res.HasMainNameCheck = true
+	}
+	cond, module, ok := parseCondition(test)
+	if ok {
+		_, ok = v.imported[importKey{name: module}]
+	}
+	if ok {
+		v.conditions = append(v.conditions, cond)
+	}
+	err := v.visitBlock(clause.body)
+	if ok {
+		v.conditions = v.conditions[:len(v.conditions)-1]
+	}
+	if err != nil || len(rest) == 0 {
+		return err
+	}
+	if ok {
+		v.conditions = append(v.conditions, negateCondition(cond))
+		defer func() { v.conditions = v.conditions[:len(v.conditions)-1] }()
+	}
+	return v.visitIf(rest)
+}
+
+// Checks if this is a typing.TYPE_CHECKING conditional, possibly negated.
 //
 // NOTE: This is a little crude, but should work for most cases. If it does not,
 // improve the logic as needed.
-func isTypeCheckingConditional(imported map[string]struct{}, stmt *ast.If) (res bool, safeBody []ast.Stmt) {
-	switch test := stmt.Test.(type) {
-	case *ast.UnaryOp:
-		if test.Op == ast.Not {
-			return isTypeCheckingConditional(imported, &ast.If{Test: test.Operand, Body: stmt.Orelse, Orelse: stmt.Body})
+func (v *visitor) isTypeCheckingConditional(test []token) (res, negated bool) {
+	for len(test) > 0 && test[0].is(tokenName, "not") {
+		negated = !negated
+		test = test[1:]
+	}
+	switch len(test) {
+	case 1:
+		if _, ok := v.imported[importKey{name: "typing.TYPE_CHECKING"}]; !ok {
+			return false, false
 		}
-	case *ast.Attribute:
-		if _, ok := imported["typing"]; !ok {
-			return false, nil
+		return test[0].is(tokenName, "TYPE_CHECKING"), negated
+	case 3:
+		if _, ok := v.imported[importKey{name: "typing"}]; !ok {
+			return false, false
 		}
-		if name, ok := test.Value.(*ast.Name); ok && name.Id == "typing" && test.Attr == "TYPE_CHECKING" {
-			return true, stmt.Orelse
+		return test[0].is(tokenName, "typing") && test[1].is(tokenOp, ".") && test[2].is(tokenName, "TYPE_CHECKING"), negated
+	}
+	return false, false
+}
+
+// Check for `if __name__ == "__main__":` blocks.
+func isMainNameCheck(test []token) bool {
+	if len(test) != 3 || !test[0].is(tokenName, "__name__") || !test[1].is(tokenOp, "==") {
+		return false
+	}
+	value, ok := stringValue(test[2])
+	return ok && value == "__main__"
+}
+
+// Returns the name of the function or class defined by the header of a def,
+// async def or class statement.
+func definedName(header []token) (string, bool) {
+	if header[0].is(tokenName, "async") {
+		header = header[1:]
+	}
+	if len(header) < 2 || !(header[0].is(tokenName, "def") || header[0].is(tokenName, "class")) || header[1].kind != tokenName {
+		return "", false
+	}
+	return header[1].text, true
+}
+
+// Returns the names bound by a simple statement if it is an assignment, an
+// annotated assignment or a type alias. For assignments to __all__, returns the
+// listed names instead.
+func assignedNames(toks []token) []string {
+	if toks[0].kind != tokenName {
+		return nil
+	}
+	if toks[0].text == "__all__" && len(toks) > 1 {
+		// Assignments, augmented assignments or calls like __all__.extend.
+		var res []string
+		for _, tok := range toks[1:] {
+			if value, ok := stringValue(tok); ok {
+				res = append(res, value)
+			}
 		}
-	case *ast.Name:
-		if _, ok := imported["typing.TYPE_CHECKING"]; !ok {
-			return false, nil
+		return res
+	}
+	if len(toks) > 2 && toks[0].is(tokenName, "type") && toks[1].kind == tokenName && (toks[2].is(tokenOp, "=") || toks[2].is(tokenOp, "[")) {
+		return []string{toks[1].text}
+	}
+	if len(toks) > 1 && toks[1].is(tokenOp, ":") && toks[0].text != "lambda" {
+		return []string{toks[0].text}
+	}
+
+	// Split chained assignments, e.g. `a = b, c = value`, at top-level equal
+	// signs; all but the last part are targets.
+	var (
+		res   []string
+		depth int
+		start int
+	)
+	for i, tok := range toks {
+		if tok.kind != tokenOp {
+			continue
 		}
-		if test.Id == "TYPE_CHECKING" {
-			return true, stmt.Orelse
+		switch tok.text {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+		case "=":
+			if depth == 0 {
+				res = append(res, targetNames(toks[start:i])...)
+				start = i + 1
+			}
 		}
 	}
-	return false, nil
+	return res
 }
 
-// Check for `if __name__ == "__main__":` blocks.
-func isMainNameCheck(stmt *ast.If) bool {
-	v, ok := stmt.Test.(*ast.Compare)
-	if !ok || len(v.Ops) != 1 || v.Ops[0] != ast.Eq || len(v.Comparators) != 1 {
-		return false
+// Returns the names in an assignment target, which may be a name, or a
+// possibly parenthesized list of targets to unpack into. Attributes and
+// subscriptions are skipped.
+func targetNames(toks []token) []string {
+	var res []string
+	depth, start := 0, 0
+	for i := 0; i <= len(toks); i++ {
+		if i < len(toks) {
+			switch tok := toks[i]; {
+			case tok.is(tokenOp, "(") || tok.is(tokenOp, "[") || tok.is(tokenOp, "{"):
+				depth++
+				continue
+			case tok.is(tokenOp, ")") || tok.is(tokenOp, "]") || tok.is(tokenOp, "}"):
+				depth--
+				continue
+			case !tok.is(tokenOp, ",") || depth > 0:
+				continue
+			}
+		}
+		target := toks[start:i]
+		start = i + 1
+		if len(target) > 0 && target[0].is(tokenOp, "*") {
+			target = target[1:]
+		}
+		switch {
+		case len(target) == 1 && target[0].kind == tokenName:
+			res = append(res, target[0].text)
+		case len(target) > 2 && (target[0].is(tokenOp, "(") && target[len(target)-1].is(tokenOp, ")") ||
+			target[0].is(tokenOp, "[") && target[len(target)-1].is(tokenOp, "]")):
+			res = append(res, targetNames(target[1:len(target)-1])...)
+		}
 	}
-	if left, ok := v.Left.(*ast.Name); !ok || left.Id != "__name__" {
-		return false
+	return res
+}
+
+// Parses an import statement, given as `import a.b [as c], ...` or
+// `from [.]a.b import c [as d], ...`. Also returns the names bound by the
+// statement in the importing module.
+func parseImport(toks []token) ([]Import, []string, error) {
+	ip := &importParser{toks: toks}
+	res, err := ip.parse()
+	if err != nil {
+		return nil, nil, fmt.Errorf("line %d: invalid import statement: %w", toks[0].line, err)
 	}
-	if right, ok := v.Comparators[0].(*ast.Str); !ok || right.S != "__main__" {
-		return false
+	return res, ip.bound, nil
+}
+
+type importParser struct {
+	toks  []token
+	pos   int
+	bound []string
+}
+
+func (ip *importParser) peek() token {
+	if ip.pos >= len(ip.toks) {
+		return token{kind: tokenEOF}
+	}
+	return ip.toks[ip.pos]
+}
+
+func (ip *importParser) next() token {
+	tok := ip.peek()
+	ip.pos++
+	return tok
+}
+
+func (ip *importParser) parse() ([]Import, error) {
+	var res []Import
+	if ip.next().is(tokenName, "import") {
+		for {
+			line := ip.peek().line
+			name, err := ip.dottedName()
+			if err != nil {
+				return nil, err
+			}
+			alias, err := ip.alias()
+			if err != nil {
+				return nil, err
+			}
+			if alias == "" {
+				alias, _, _ = strings.Cut(name, ".")
+			}
+			ip.bound = append(ip.bound, alias)
+			res = append(res, Import{Name: name, Line: line})
+			if !ip.peek().is(tokenOp, ",") {
+				break
+			}
+			ip.next()
+		}
+		return res, ip.end()
+	}
+
+	level := 0
+	for {
+		if tok := ip.peek(); tok.is(tokenOp, ".") || tok.is(tokenOp, "...") {
+			level += len(ip.next().text)
+			continue
+		}
+		break
+	}
+	var module string
+	if !ip.peek().is(tokenName, "import") {
+		var err error
+		if module, err = ip.dottedName(); err != nil {
+			return nil, err
+		}
+	}
+	if level == 0 && module == "" {
+		return nil, fmt.Errorf("missing module name")
+	}
+	if !ip.next().is(tokenName, "import") {
+		return nil, fmt.Errorf("expected %q", "import")
+	}
+	parenthesized := ip.peek().is(tokenOp, "(")
+	if parenthesized {
+		ip.next()
+	}
+	for {
+		tok := ip.next()
+		if tok.kind != tokenName && !tok.is(tokenOp, "*") {
+			return nil, fmt.Errorf("expected a name, got %q", tok.text)
+		}
+		name := tok.text
+		alias, err := ip.alias()
+		if err != nil {
+			return nil, err
+		}
+		if alias == "" {
+			alias = name
+		}
+		ip.bound = append(ip.bound, alias)
+		if module != "" {
+			name = module + "." + name
+		}
+		res = append(res, Import{Name: name, Level: level, Line: tok.line})
+		if !ip.peek().is(tokenOp, ",") {
+			break
+		}
+		ip.next()
+		if parenthesized && ip.peek().is(tokenOp, ")") {
+			break
+		}
+	}
+	if parenthesized && !ip.next().is(tokenOp, ")") {
+		return nil, fmt.Errorf("expected %q", ")")
+	}
+	return res, ip.end()
+}
+
+func (ip *importParser) dottedName() (string, error) {
+	var parts []string
+	for {
+		tok := ip.next()
+		if tok.kind != tokenName {
+			return "", fmt.Errorf("expected a name, got %q", tok.text)
+		}
+		parts = append(parts, tok.text)
+		if !ip.peek().is(tokenOp, ".") {
+			return strings.Join(parts, "."), nil
+		}
+		ip.next()
+	}
+}
+
+// Consumes an optional `as name` clause, and returns the name.
+func (ip *importParser) alias() (string, error) {
+	if !ip.peek().is(tokenName, "as") {
+		return "", nil
+	}
+	ip.next()
+	tok := ip.next()
+	if tok.kind != tokenName {
+		return "", fmt.Errorf("expected a name, got %q", tok.text)
+	}
+	return tok.text, nil
+}
+
+func (ip *importParser) end() error {
+	if tok := ip.peek(); tok.kind != tokenEOF {
+		return fmt.Errorf("unexpected %q", tok.text)
 	}
-	return true
+	return nil
 }
@@ -15,12 +15,17 @@
 package parser
 
 import (
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
+// Positions of imports are checked separately in TestParseImportLines.
+var ignoreImportLines = cmpopts.IgnoreFields(Import{}, "Line")
+
 func TestParse(t *testing.T) {
 	cases := []struct {
 		content string
@@ -29,24 +34,39 @@ func TestParse(t *testing.T) {
 		// Base case.
 		{"", Result{}},
 		// Top-level imports.
-		{"import mod1", Result{[]string{"mod1"}, false}},
-		{"from mod1 import foo", Result{[]string{"mod1.foo"}, false}},
-		{"from mod1 import foo, bar", Result{[]string{"mod1.bar", "mod1.foo"}, false}},
-		{"from mod1 import (foo, bar)", Result{[]string{"mod1.bar", "mod1.foo"}, false}},
-		{"from mod1 import foo, bar; import mod2.baz", Result{[]string{"mod1.bar", "mod1.foo", "mod2.baz"}, false}},
+		{"import mod1", Result{Imports: []Import{{Name: "mod1"}}, Symbols: []string{"mod1"}}},
+		{"from mod1 import foo", Result{Imports: []Import{{Name: "mod1.foo"}}, Symbols: []string{"foo"}}},
+		{"from mod1 import foo, bar", Result{Imports: []Import{{Name: "mod1.bar"}, {Name: "mod1.foo"}}, Symbols: []string{"bar", "foo"}}},
+		{"from mod1 import (foo, bar)", Result{Imports: []Import{{Name: "mod1.bar"}, {Name: "mod1.foo"}}, Symbols: []string{"bar", "foo"}}},
+		{"from mod1 import foo, bar; import mod2.baz", Result{Imports: []Import{{Name: "mod1.bar"}, {Name: "mod1.foo"}, {Name: "mod2.baz"}}, Symbols: []string{"bar", "foo", "mod2"}}},
+		// Relative imports.
+		{"from . import foo", Result{Imports: []Import{{Name: "foo", Level: 1}}, Symbols: []string{"foo"}}},
+		{"from .mod1 import foo", Result{Imports: []Import{{Name: "mod1.foo", Level: 1}}, Symbols: []string{"foo"}}},
+		{"from .. import foo", Result{Imports: []Import{{Name: "foo", Level: 2}}, Symbols: []string{"foo"}}},
+		{"from ..mod1 import foo, bar", Result{Imports: []Import{{Name: "mod1.bar", Level: 2}, {Name: "mod1.foo", Level: 2}}, Symbols: []string{"bar", "foo"}}},
+		{"from .foo import bar\nimport foo.bar", Result{Imports: []Import{{Name: "foo.bar"}, {Name: "foo.bar", Level: 1}}, Symbols: []string{"bar", "foo"}}},
 		// Conditional imports.
-		{"if False:\n\timport mod1", Result{[]string{"mod1"}, false}},
-		{"if False:\n\tfrom mod1 import foo", Result{[]string{"mod1.foo"}, false}},
-		{"def fn():\n\timport foo", Result{[]string{"foo"}, false}},
-		{"def fn():\n\tfrom mod1 import foo", Result{[]string{"mod1.foo"}, false}},
+		{"if False:\n\timport mod1", Result{Imports: []Import{{Name: "mod1"}}, Symbols: []string{"mod1"}}},
+		{"if False:\n\tfrom mod1 import foo", Result{Imports: []Import{{Name: "mod1.foo"}}, Symbols: []string{"foo"}}},
+		{"def fn():\n\timport foo", Result{Imports: []Import{{Name: "foo"}}, Symbols: []string{"fn"}}},
+		{"def fn():\n\tfrom mod1 import foo", Result{Imports: []Import{{Name: "mod1.foo"}}, Symbols: []string{"fn"}}},
 		// Type checking imports.
-		{"from typing import TYPE_CHECKING\nif TYPE_CHECKING:\n\timport mod1", Result{[]string{"typing.TYPE_CHECKING"}, false}},
-		{"import typing\nif typing.TYPE_CHECKING:\n\timport mod1", Result{[]string{"typing"}, false}},
+		{"from typing import TYPE_CHECKING\nif TYPE_CHECKING:\n\timport mod1", Result{Imports: []Import{{Name: "typing.TYPE_CHECKING"}}, TypeOnlyImports: []Import{{Name: "mod1"}}, Symbols: []string{"TYPE_CHECKING"}}},
+		{"import typing\nif typing.TYPE_CHECKING:\n\timport mod1", Result{Imports: []Import{{Name: "typing"}}, TypeOnlyImports: []Import{{Name: "mod1"}}, Symbols: []string{"typing"}}},
 		// Type checking imports -- negations.
-		{"from typing import TYPE_CHECKING\nif not TYPE_CHECKING:\n\timport mod1\nelse:\n\timport mod2", Result{[]string{"mod1", "typing.TYPE_CHECKING"}, false}},
-		{"import typing\nif not typing.TYPE_CHECKING:\n\timport mod1\nelse:\n\timport mod2", Result{[]string{"mod1", "typing"}, false}},
+		{"from typing import TYPE_CHECKING\nif not TYPE_CHECKING:\n\timport mod1\nelse:\n\timport mod2", Result{Imports: []Import{{Name: "mod1"}, {Name: "typing.TYPE_CHECKING"}}, TypeOnlyImports: []Import{{Name: "mod2"}}, Symbols: []string{"TYPE_CHECKING", "mod1"}}},
+		{"import typing\nif not typing.TYPE_CHECKING:\n\timport mod1\nelse:\n\timport mod2", Result{Imports: []Import{{Name: "mod1"}, {Name: "typing"}}, TypeOnlyImports: []Import{{Name: "mod2"}}, Symbols: []string{"mod1", "typing"}}},
+		{"from __future__ import annotations\nfrom typing import TYPE_CHECKING\nimport mod1\nif TYPE_CHECKING:\n\tfrom mod1 import A\n\tfrom mod2 import B\n\timport mod1\n\tx = 1\ndef f(a: A) -> B: pass", Result{Imports: []Import{{Name: "__future__.annotations"}, {Name: "mod1"}, {Name: "typing.TYPE_CHECKING"}}, TypeOnlyImports: []Import{{Name: "mod1.A"}, {Name: "mod2.B"}}, Symbols: []string{"TYPE_CHECKING", "annotations", "f", "mod1"}}},
+		{"import typing\nif typing.TYPE_CHECKING:\n\timport mod1\nelif x:\n\timport mod2\nelse:\n\timport mod3", Result{Imports: []Import{{Name: "mod2"}, {Name: "mod3"}, {Name: "typing"}}, TypeOnlyImports: []Import{{Name: "mod1"}}, Symbols: []string{"mod2", "mod3", "typing"}}},
 		// Main block.
-		{"if __name__ == \"__main__\":\n\tmain()", Result{nil, true}},
+		{"if __name__ == \"__main__\":\n\tmain()", Result{HasMainNameCheck: true}},
+		// Top-level symbols.
+		{"def fn():\n\tx = 1\nasync def afn(): pass\n@decorator\nclass Cls:\n\ty = 2", Result{Symbols: []string{"Cls", "afn", "fn"}}},
+		{"a = b = 1\nc, (d, *e) = [f, g.h] = x\ni[0] = j.k = 2\nl += 1", Result{Symbols: []string{"a", "b", "c", "d", "e", "f"}}},
+		{"x: int\ny: dict[str, int] = {}\ntype Z[T] = list[T]\nf(a=1)\nlambda: 0", Result{Symbols: []string{"Z", "x", "y"}}},
+		{"import a.b, c.d as e\nfrom f import g as h, *", Result{Imports: []Import{{Name: "a.b"}, {Name: "c.d"}, {Name: "f.*"}, {Name: "f.g"}}, Symbols: []string{"*", "a", "e", "h"}}},
+		{"__all__ = ['a', \"b\"]\n__all__ += ('c',)\n__all__.append('d')", Result{Symbols: []string{"a", "b", "c", "d"}}},
+		{"try:\n\tfrom json import loads\nexcept ImportError:\n\tloads = None\nif True:\n\tdef fn(): pass", Result{Imports: []Import{{Name: "json.loads", Optional: true}}, Symbols: []string{"fn", "loads"}}},
 	}
 
 	for i, testCase := range cases {
@@ -55,8 +75,233 @@ func TestParse(t *testing.T) {
 			t.Errorf("test %d: unexpected error: %v", i, err)
 			continue
 		}
-		if diff := cmp.Diff(res, testCase.want); diff != "" {
+		if diff := cmp.Diff(res, testCase.want, ignoreImportLines); diff != "" {
 			t.Errorf("test %d: (-got, +want):%s", i, diff)
 		}
 	}
 }
+
+// Conformance corpus of modules using syntax from recent Python versions.
+func TestParseConformance(t *testing.T) {
+	cases := []struct {
+		filename string
+		want     Result
+	}{
+		{"async.py", Result{Imports: []Import{{Name: "async_mod1"}, {Name: "async_mod2.foo"}, {Name: "async_mod3"}, {Name: "asyncio"}}, Symbols: []string{"Worker", "asyncio", "main"}}},
+		{"exceptions.py", Result{Imports: []Import{{Name: "exceptions_mod1"}, {Name: "exceptions_mod2"}, {Name: "exceptions_mod3.foo"}, {Name: "exceptions_mod4"}, {Name: "exceptions_mod5"}, {Name: "exceptions_mod6"}, {Name: "exceptions_mod7"}}, Symbols: []string{"exceptions_mod1", "exceptions_mod2", "exceptions_mod4", "exceptions_mod5", "exceptions_mod6", "exceptions_mod7", "foo"}}},
+		{"fstrings.py", Result{Imports: []Import{{Name: "fstrings_mod1"}, {Name: "fstrings_mod2.foo"}}, Symbols: []string{"foo", "fstrings_mod1", "name", "width"}}},
+		{"generics.py", Result{Imports: []Import{{Name: "generics_mod1"}, {Name: "generics_mod2"}, {Name: "generics_mod3.foo"}, {Name: "generics_mod4"}}, Symbols: []string{"Alias", "Box", "Point", "first", "generics_mod1"}}},
+		{"match.py", Result{Imports: []Import{{Name: "match_mod1"}, {Name: "match_mod2"}, {Name: "match_mod3.foo"}, {Name: "match_mod4"}, {Name: "match_mod5"}}, Symbols: []string{"case", "command", "foo", "match", "match_mod1", "match_mod2", "match_mod4", "match_mod5"}}},
+		{"misc.py", Result{Imports: []Import{{Name: "misc_mod1"}, {Name: "misc_mod2"}, {Name: "misc_mod3.bar"}, {Name: "misc_mod3.foo"}, {Name: "misc_mod4"}, {Name: "misc_mod5"}, {Name: "misc_mod6"}, {Name: "misc_mod7", Level: 1}, {Name: "misc_mod8.*", Level: 2}}, Symbols: []string{"*", "b", "baz", "f", "foo", "misc_mod1", "misc_mod4", "misc_mod5", "misc_mod7", "n", "s", "two", "x"}}},
+		{"posonly.py", Result{Imports: []Import{{Name: "posonly_mod1"}, {Name: "posonly_mod2"}, {Name: "posonly_mod3.foo"}}, Symbols: []string{"f", "g", "h", "posonly_mod1"}}},
+		{"walrus.py", Result{Imports: []Import{{Name: "re"}, {Name: "walrus_mod1"}, {Name: "walrus_mod2.foo"}}, Symbols: []string{"data", "foo", "re", "walrus_mod1"}}},
+	}
+
+	for _, testCase := range cases {
+		res, err := ParsePath(filepath.Join("testdata", testCase.filename))
+		if err != nil {
+			t.Errorf("test %s: unexpected error: %v", testCase.filename, err)
+			continue
+		}
+		if diff := cmp.Diff(res, testCase.want, ignoreImportLines); diff != "" {
+			t.Errorf("test %s: (-got, +want):%s", testCase.filename, diff)
+		}
+	}
+}
+
+func TestParseDynamicImports(t *testing.T) {
+	cases := []struct {
+		content string
+		want    []Import
+	}{
+		{"import importlib\nm = importlib.import_module('a.b')", []Import{{Name: "a.b", Dynamic: true}, {Name: "importlib"}}},
+		{"from importlib import import_module\ndef f():\n\treturn import_module(name='a.b')", []Import{{Name: "a.b", Dynamic: true}, {Name: "importlib.import_module"}}},
+		{"__import__('a.b')\n__import__('c', globals(), level=1)", []Import{{Name: "a.b", Dynamic: true}, {Name: "c", Level: 1, Dynamic: true}}},
+		// Relative names anchored to a literal package or __package__.
+		{"import importlib\nimportlib.import_module('.c', 'a.b')\nimportlib.import_module('..d', package='a.b')", []Import{{Name: "a.b.c", Dynamic: true}, {Name: "a.d", Dynamic: true}, {Name: "importlib"}}},
+		{"import importlib\nimportlib.import_module('.c', __package__)", []Import{{Name: "importlib"}, {Name: "c", Level: 1, Dynamic: true}}},
+		{"import importlib\nimportlib.import_module('.c')\nimportlib.import_module('...c', 'a')", []Import{{Name: "importlib"}}},
+		// Other functions, in headers of compound statements.
+		{"import importlib.util\nif importlib.util.find_spec('a') is None:\n\tpass", []Import{{Name: "a", Dynamic: true}, {Name: "importlib.util"}}},
+		{"from importlib import util\nwith open(util.find_spec('a').origin) as f:\n\tpass", []Import{{Name: "a", Dynamic: true}, {Name: "importlib.util"}}},
+		{"import pkgutil\npkgutil.resolve_name('a.b:C.d')\npkgutil.get_loader('e')", []Import{{Name: "a.b", Dynamic: true}, {Name: "e", Dynamic: true}, {Name: "pkgutil"}}},
+		// Static imports take precedence.
+		{"import importlib, a\nimportlib.import_module('a')", []Import{{Name: "a"}, {Name: "importlib"}}},
+		// Not dynamic imports: functions not imported, and names which are
+		// not literals.
+		{"import_module('a')\nimportlib.import_module('b')\nother.import_module('c')", nil},
+		{"import importlib\nimportlib.import_module(name)\nimportlib.import_module(f'a.{b}')\nimportlib.import_module('a-b')", []Import{{Name: "importlib"}}},
+	}
+	for i, testCase := range cases {
+		res, err := Parse(strings.NewReader(testCase.content), "")
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if diff := cmp.Diff(res.Imports, testCase.want, ignoreImportLines); diff != "" {
+			t.Errorf("test %d: (-got, +want):%s", i, diff)
+		}
+	}
+}
+
+func TestParseOptionalImports(t *testing.T) {
+	cases := []struct {
+		content string
+		want    []Import
+	}{
+		{"try:\n\timport ujson as json\nexcept ImportError:\n\timport json", []Import{{Name: "json"}, {Name: "ujson", Optional: true}}},
+		{"try:\n\tfrom a import b\nexcept (ValueError, ModuleNotFoundError) as e:\n\tpass\nelse:\n\timport c", []Import{{Name: "a.b", Optional: true}, {Name: "c"}}},
+		{"try:\n\timport a\nexcept:\n\tpass\nfinally:\n\timport b", []Import{{Name: "a", Optional: true}, {Name: "b"}}},
+		{"try:\n\tif True:\n\t\timport a\nexcept ImportError:\n\tpass", []Import{{Name: "a", Optional: true}}},
+		// Imported elsewhere without a handler for ImportError.
+		{"try:\n\timport a\nexcept ImportError:\n\tpass\nimport a", []Import{{Name: "a"}}},
+		{"import a\ntry:\n\timport a\nexcept ImportError:\n\tpass", []Import{{Name: "a"}}},
+		{"try:\n\timport a\nexcept ValueError as ImportError:\n\tpass", []Import{{Name: "a"}}},
+		// Dynamic imports.
+		{"import importlib\ntry:\n\timportlib.import_module('a')\nexcept ImportError:\n\tpass", []Import{{Name: "a", Dynamic: true, Optional: true}, {Name: "importlib"}}},
+	}
+	for i, testCase := range cases {
+		res, err := Parse(strings.NewReader(testCase.content), "")
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if diff := cmp.Diff(res.Imports, testCase.want, ignoreImportLines); diff != "" {
+			t.Errorf("test %d: (-got, +want):%s", i, diff)
+		}
+	}
+}
+
+func TestParseConditionalImports(t *testing.T) {
+	cases := []struct {
+		content string
+		want    []Import
+	}{
+		{"import sys\nif sys.version_info >= (3, 11):\n\timport tomllib\nelse:\n\timport tomli", []Import{{Name: "sys"}, {Name: "tomli", Condition: "not sys.version_info >= (3, 11)"}, {Name: "tomllib", Condition: "sys.version_info >= (3, 11)"}}},
+		{"import sys\nif sys.platform=='win32':\n\timport winreg\nelif not sys.platform.startswith('linux'):\n\timport a\nelse:\n\timport b", []Import{{Name: "a", Condition: `not sys.platform == "win32" and not sys.platform.startswith("linux")`}, {Name: "b", Condition: `not sys.platform == "win32" and sys.platform.startswith("linux")`}, {Name: "sys"}, {Name: "winreg", Condition: `sys.platform == "win32"`}}},
+		{"import os, sys\nif not os.name != 'nt':\n\tif sys.version_info[:2] < (3,8):\n\t\timport a", []Import{{Name: "a", Condition: `not os.name != "nt" and sys.version_info[:2] < (3, 8)`}, {Name: "os"}, {Name: "sys"}}},
+		// Imported under different conditions, or also unconditionally.
+		{"import sys\nif sys.platform == 'win32':\n\timport a\nelse:\n\timport a\n\timport b\nimport b", []Import{{Name: "a"}, {Name: "b"}, {Name: "sys"}}},
+		// Not conditions on the version or the platform.
+		{"if sys.platform == 'win32':\n\timport a", []Import{{Name: "a"}}},
+		{"import sys\nif sys.platform == 'win32' and x:\n\timport a\nif sys.flags.debug:\n\timport b", []Import{{Name: "a"}, {Name: "b"}, {Name: "sys"}}},
+	}
+	for i, testCase := range cases {
+		res, err := Parse(strings.NewReader(testCase.content), "")
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if diff := cmp.Diff(res.Imports, testCase.want, ignoreImportLines); diff != "" {
+			t.Errorf("test %d: (-got, +want):%s", i, diff)
+		}
+	}
+}
+
+func TestParseAnnotations(t *testing.T) {
+	cases := []struct {
+		content string
+		want    Result
+	}{
+		{"import a  # gazelle:ignore\nimport b  # noqa: F401  # gazelle:dep //b:lib :c", Result{Imports: []Import{{Name: "a", Ignore: true}, {Name: "b", Deps: []string{"//b:lib", ":c"}}}}},
+		// Preceding comments, including after blank lines of other comments.
+		{"# gazelle:ignore\n# gazelle:dep //x\nimport a\n# gazelle:ignore\n\nimport b", Result{Imports: []Import{{Name: "a", Ignore: true, Deps: []string{"//x"}}, {Name: "b"}}}},
+		// Statements spanning several lines.
+		{"from p import (  # gazelle:dep //p\n\t# gazelle:ignore\n\ta,\n\tb,  # gazelle:dep //b\n\tc,\n)", Result{Imports: []Import{{Name: "p.a", Ignore: true, Deps: []string{"//p"}}, {Name: "p.b", Deps: []string{"//p", "//b"}}, {Name: "p.c", Deps: []string{"//p"}}}}},
+		// Ignored only if all imports are ignored.
+		{"import a  # gazelle:ignore\nimport a\nimport b  # gazelle:dep //x\nimport b  # gazelle:dep //y", Result{Imports: []Import{{Name: "a"}, {Name: "b", Deps: []string{"//x", "//y"}}}}},
+		{"import importlib\nimportlib.import_module('a')  # gazelle:dep //a", Result{Imports: []Import{{Name: "a", Dynamic: true, Deps: []string{"//a"}}, {Name: "importlib"}}}},
+		// Pragmas, and comments which are not annotations.
+		{"#!/usr/bin/env python\n# gazelle:py_kind py_binary\n# gazelle:py_ignore_file\nx = 1  # gazelle:py_kind py_test\n# gazelle:unknown\n'# gazelle:ignore'", Result{RuleKind: "py_binary", IgnoreFile: true}},
+	}
+	for i, testCase := range cases {
+		res, err := Parse(strings.NewReader(testCase.content), "")
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if diff := cmp.Diff(res, testCase.want, ignoreImportLines, cmpopts.IgnoreFields(Result{}, "Symbols")); diff != "" {
+			t.Errorf("test %d: (-got, +want):%s", i, diff)
+		}
+	}
+}
+
+func TestNormalizeCondition(t *testing.T) {
+	cases := []struct {
+		expr, want string
+	}{
+		{"sys.version_info>=(3,11)", "sys.version_info >= (3, 11)"},
+		{" sys.platform  ==  'win32' ", `sys.platform == "win32"`},
+		{"not not os.name in ('nt',)", `os.name in ("nt",)`},
+		{"not sys.platform.startswith('linux')", `not sys.platform.startswith("linux")`},
+		{"sys.version_info[0]==3", "sys.version_info[0] == 3"},
+	}
+	for i, testCase := range cases {
+		got, err := NormalizeCondition(testCase.expr)
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if diff := cmp.Diff(got, testCase.want); diff != "" {
+			t.Errorf("test %d: (-got, +want):%s", i, diff)
+		}
+	}
+	for _, expr := range []string{"", "x == 1", "sys.maxsize > 2**32", "sys.platform == 'win32' or x"} {
+		if _, err := NormalizeCondition(expr); err == nil {
+			t.Errorf("expected error for %q", expr)
+		}
+	}
+}
+
+func TestParseImportLines(t *testing.T) {
+	content := `"""Docstring."""
+import a, b.c
+from d import (
+    e,
+    f as g,
+)
+from . import h
+
+def fn():
+    import a
+    import i
+`
+	res, err := Parse(strings.NewReader(content), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Import{
+		{Name: "a", Line: 2},
+		{Name: "b.c", Line: 2},
+		{Name: "d.e", Line: 4},
+		{Name: "d.f", Line: 5},
+		{Name: "i", Line: 11},
+		{Name: "h", Level: 1, Line: 7},
+	}
+	if diff := cmp.Diff(res.Imports, want); diff != "" {
+		t.Errorf("(-got, +want):%s", diff)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []string{
+		"import",
+		"import mod1.",
+		"from import foo",
+		"from mod1 import",
+		"from mod1 import (foo",
+		"x = (1,",
+		"x = 'unterminated",
+		"x = \"\"\"unterminated",
+		"x = f'{unterminated'",
+		"if True:\n\t\tpass\n\tpass",
+		"if True:\npass",
+		"x = 1 $ 2",
+	}
+
+	for i, content := range cases {
+		if _, err := Parse(strings.NewReader(content), ""); err == nil {
+			t.Errorf("test %d: expected error for %q", i, content)
+		}
+	}
+}
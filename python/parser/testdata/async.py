@@ -0,0 +1,18 @@
+# Coroutines with async and await.
+import asyncio
+
+
+async def main():
+    import async_mod1
+
+    async with asyncio.timeout(1) as t:
+        from async_mod2 import foo
+
+    async for item in aiter(foo):
+        await asyncio.sleep(0)
+    result = [await x async for x in foo]
+
+
+class Worker:
+    async def run(self):
+        import async_mod3
@@ -0,0 +1,22 @@
+# Exception groups from Python 3.11, and other compound statement clauses.
+try:
+    import exceptions_mod1
+except* ValueError:
+    import exceptions_mod2
+except* (TypeError, KeyError) as eg:
+    from exceptions_mod3 import foo
+else:
+    import exceptions_mod4
+finally:
+    import exceptions_mod5
+
+for i in range(3):
+    pass
+else:
+    import exceptions_mod6
+
+with (
+    open("a") as a,
+    open("b") as b,
+):
+    import exceptions_mod7
@@ -0,0 +1,24 @@
+# f-strings, including nested quotes and replacement fields from Python 3.12.
+import fstrings_mod1
+
+name = "world"
+width = 10
+print(f"hello {name}")
+print(f"{name!r:>{width}} {{literal braces}}")
+print(f"{name:'>10}")
+print(f"{'nested'} {f'{name}'}")
+print(f"{"same quotes"}")
+print(f"{f"{f"{name}"}"}")
+print(rf"\{name}" Rf'{name}' fR"""{
+    name
+}""")
+print(f"{name # a comment in a multi-line replacement field
+}")
+print(f"{name=}, {width!s}, {width:{'>' if width else '<'}10}")
+print(f"{ {'a': 1}['a'] } {[x for x in 'abc']}")
+print(fr'\{{\}}', f"\N{BULLET} {name}\\", f'\'{name}\'')
+print(f"""
+import not_an_import
+""")
+
+from fstrings_mod2 import foo
@@ -0,0 +1,17 @@
+# Type parameter syntax from Python 3.12 (PEP 695).
+import generics_mod1
+
+type Alias[T] = list[T]
+type Point = tuple[float, float]
+
+
+def first[T](items: list[T]) -> T:
+    import generics_mod2
+    return items[0]
+
+
+class Box[T: (int, str), *Ts, **P]:
+    from generics_mod3 import foo
+
+    def get[U](self, default: U) -> T | U:
+        import generics_mod4
@@ -0,0 +1,29 @@
+# Structural pattern matching from Python 3.10, and match as a name.
+import match_mod1
+
+match = {"a": 1}
+match["b"] = 2
+match.get("a")
+match(1)
+
+command = "go north"
+match command.split():
+    case [action]:
+        import match_mod2
+    case [action, obj] if obj in {"north": 1}:
+        from match_mod3 import foo
+    case {"x": x, **rest}:
+        pass
+    case Point(x=0, y=0) | None:
+        pass
+    case _: import match_mod4
+
+match (command):
+    case "quit":
+        import match_mod5
+
+match ...:
+    case _:
+        pass
+
+case = 1
@@ -0,0 +1,30 @@
+# Line structure: continuations, semicolons, decorators, comments and strings.
+import misc_mod1, \
+    misc_mod2 as two
+from misc_mod3 import (
+    foo,  # a comment
+    bar as baz,
+)
+import misc_mod4; x = 1; import misc_mod5
+
+"""import not_an_import"""
+s = 'import not_an_import'
+b = b"import not_an_import"
+n = 0x1E+1, 1e-5, 1_000.5j, .5
+
+
+@decorator(arg=lambda: 1)
+def f():
+    if True: import misc_mod6
+    # import not_an_import
+    return 1
+
+
+if x:
+    pass
+elif y:
+    from . import misc_mod7
+else:
+    from ..misc_mod8 import *
+
+print >>sys.stderr, "no print statement parsing needed"
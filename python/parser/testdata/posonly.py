@@ -0,0 +1,14 @@
+# Positional-only parameters from Python 3.8, and other parameter forms.
+import posonly_mod1
+
+
+def f(a, b, /, c, d=lambda: 1, *, e: int = 2, **kwargs) -> int:
+    import posonly_mod2
+    return a
+
+
+def g(a, /):
+    from posonly_mod3 import foo
+
+
+h = lambda x, /, y: x + y
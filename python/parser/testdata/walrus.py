@@ -0,0 +1,10 @@
+# Assignment expressions from Python 3.8.
+import re
+
+if (match := re.match("a", "a")) is not None:
+    import walrus_mod1
+
+while chunk := input():
+    from walrus_mod2 import foo
+
+data = [y for x in range(3) if (y := x * 2)]
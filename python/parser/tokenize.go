@@ -0,0 +1,497 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenName
+	tokenNumber
+	tokenString
+	tokenOp
+	tokenNewline
+	tokenIndent
+	tokenDedent
+)
+
+type token struct {
+	kind tokenKind
+	text string // Source text of the token; empty for NEWLINE, INDENT, DEDENT and EOF.
+	line int    // Line number (1-based) where the token starts.
+}
+
+func (t token) is(kind tokenKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+// Operators and delimiters, longest first so that the first match is the
+// longest match.
+var operators = []string{
+	"**=", "//=", ">>=", "<<=", "...",
+	"->", ":=", "**", "//", "==", "!=", "<=", ">=", "<<", ">>",
+	"+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "@=",
+	"(", ")", "[", "]", "{", "}", ",", ":", ";", ".", "@", "=",
+	"+", "-", "*", "/", "%", "&", "|", "^", "~", "<", ">", "!",
+}
+
+// tokenizer splits Python source into tokens, following the lexical analysis
+// of the Python language reference closely enough to find the statements in a
+// module. It understands the syntax of all Python 3 versions, including nested
+// f-strings from Python 3.12. Comments are not tokens, but are collected
+// separately for annotations.
+type tokenizer struct {
+	src         string
+	pos         int
+	line        int
+	indents     []int // Stack of indentation columns.
+	depth       int   // Nesting depth of brackets.
+	atLineStart bool
+	toks        []token
+	comments    []comment
+}
+
+// comment is a comment in the source, other than in f-string replacement
+// fields.
+type comment struct {
+	text    string // Source text of the comment, including the leading "#".
+	line    int    // Line number (1-based) of the comment.
+	ownLine bool   // Whether the comment is the only content on its line.
+}
+
+func tokenize(src string) ([]token, error) {
+	toks, _, err := tokenizeWithComments(src)
+	return toks, err
+}
+
+// Returns the tokens of the source, and its comments in order.
+func tokenizeWithComments(src string) ([]token, []comment, error) {
+	t := &tokenizer{
+		src:         strings.TrimPrefix(src, "\ufeff"),
+		line:        1,
+		indents:     []int{0},
+		atLineStart: true,
+	}
+	if err := t.run(); err != nil {
+		return nil, nil, fmt.Errorf("line %d: %w", t.line, err)
+	}
+	return t.toks, t.comments, nil
+}
+
+func (t *tokenizer) emit(kind tokenKind, start int, line int) {
+	t.toks = append(t.toks, token{kind: kind, text: t.src[start:t.pos], line: line})
+}
+
+func (t *tokenizer) run() error {
+	for {
+		if t.atLineStart && t.depth == 0 {
+			done, err := t.indentation()
+			if err != nil {
+				return err
+			}
+			if done {
+				continue
+			}
+		}
+		t.skipSpaces()
+		if t.pos >= len(t.src) {
+			break
+		}
+		start, line := t.pos, t.line
+		c := t.src[t.pos]
+		switch {
+		case c == '#':
+			t.scanComment()
+		case c == '\n' || c == '\r':
+			t.newline()
+			if t.depth == 0 {
+				t.toks = append(t.toks, token{kind: tokenNewline, line: line})
+				t.atLineStart = true
+			}
+		case c == '\\':
+			t.pos++
+			if t.pos >= len(t.src) || (t.src[t.pos] != '\n' && t.src[t.pos] != '\r') {
+				return fmt.Errorf("unexpected character after line continuation character")
+			}
+			t.newline()
+		case isNameStart(c):
+			if t.stringPrefix() {
+				if err := t.scanString(); err != nil {
+					return err
+				}
+				t.emit(tokenString, start, line)
+				continue
+			}
+			for t.pos < len(t.src) && isNameChar(t.src[t.pos]) {
+				t.pos++
+			}
+			t.emit(tokenName, start, line)
+		case c == '"' || c == '\'':
+			if err := t.scanString(); err != nil {
+				return err
+			}
+			t.emit(tokenString, start, line)
+		case isDigit(c) || (c == '.' && t.pos+1 < len(t.src) && isDigit(t.src[t.pos+1])):
+			t.scanNumber()
+			t.emit(tokenNumber, start, line)
+		default:
+			op := t.operator()
+			if op == "" {
+				return fmt.Errorf("invalid character %q", c)
+			}
+			switch op {
+			case "(", "[", "{":
+				t.depth++
+			case ")", "]", "}":
+				if t.depth == 0 {
+					return fmt.Errorf("unmatched %q", op)
+				}
+				t.depth--
+			}
+			t.pos += len(op)
+			t.emit(tokenOp, start, line)
+		}
+	}
+	if t.depth > 0 {
+		return fmt.Errorf("unexpected EOF in multi-line statement")
+	}
+	if n := len(t.toks); n > 0 && t.toks[n-1].kind != tokenNewline && t.toks[n-1].kind != tokenDedent {
+		t.toks = append(t.toks, token{kind: tokenNewline, line: t.line})
+	}
+	for len(t.indents) > 1 {
+		t.indents = t.indents[:len(t.indents)-1]
+		t.toks = append(t.toks, token{kind: tokenDedent, line: t.line})
+	}
+	t.toks = append(t.toks, token{kind: tokenEOF, line: t.line})
+	return nil
+}
+
+// Processes the indentation at the start of a line, emitting INDENT and DEDENT
+// tokens as needed. Returns true if the line was blank or had only a comment,
+// and has been consumed.
+func (t *tokenizer) indentation() (bool, error) {
+	col := 0
+	for ; t.pos < len(t.src); t.pos++ {
+		switch t.src[t.pos] {
+		case ' ':
+			col++
+			continue
+		case '\t':
+			col = (col/8 + 1) * 8
+			continue
+		case '\f':
+			col = 0
+			continue
+		}
+		break
+	}
+	if t.pos >= len(t.src) {
+		return false, nil
+	}
+	switch t.src[t.pos] {
+	case '#':
+		t.scanComment()
+		return true, nil
+	case '\n', '\r':
+		t.newline()
+		return true, nil
+	}
+	t.atLineStart = false
+	if top := t.indents[len(t.indents)-1]; col > top {
+		t.indents = append(t.indents, col)
+		t.toks = append(t.toks, token{kind: tokenIndent, line: t.line})
+		return false, nil
+	}
+	for col < t.indents[len(t.indents)-1] {
+		t.indents = t.indents[:len(t.indents)-1]
+		t.toks = append(t.toks, token{kind: tokenDedent, line: t.line})
+	}
+	if col != t.indents[len(t.indents)-1] {
+		return false, fmt.Errorf("unindent does not match any outer indentation level")
+	}
+	return false, nil
+}
+
+func (t *tokenizer) skipSpaces() {
+	for t.pos < len(t.src) {
+		switch t.src[t.pos] {
+		case ' ', '\t', '\f':
+			t.pos++
+		default:
+			return
+		}
+	}
+}
+
+// Consumes a comment, which must be at the current position, and records it.
+func (t *tokenizer) scanComment() {
+	start := t.pos
+	t.skipComment()
+	ownLine := true
+	if n := len(t.toks); n > 0 {
+		// Strings may span lines.
+		last := t.toks[n-1]
+		ownLine = last.line+strings.Count(last.text, "\n") < t.line
+	}
+	t.comments = append(t.comments, comment{text: t.src[start:t.pos], line: t.line, ownLine: ownLine})
+}
+
+func (t *tokenizer) skipComment() {
+	for t.pos < len(t.src) && t.src[t.pos] != '\n' && t.src[t.pos] != '\r' {
+		t.pos++
+	}
+}
+
+// Consumes a line ending, which must be at the current position.
+func (t *tokenizer) newline() {
+	if t.src[t.pos] == '\r' && t.pos+1 < len(t.src) && t.src[t.pos+1] == '\n' {
+		t.pos++
+	}
+	t.pos++
+	t.line++
+}
+
+func (t *tokenizer) operator() string {
+	for _, op := range operators {
+		if strings.HasPrefix(t.src[t.pos:], op) {
+			return op
+		}
+	}
+	return ""
+}
+
+func (t *tokenizer) scanNumber() {
+	hex := strings.HasPrefix(t.src[t.pos:], "0x") || strings.HasPrefix(t.src[t.pos:], "0X")
+	for t.pos < len(t.src) {
+		c := t.src[t.pos]
+		switch {
+		case (c == 'e' || c == 'E') && !hex:
+			t.pos++
+			if t.pos < len(t.src) && (t.src[t.pos] == '+' || t.src[t.pos] == '-') {
+				t.pos++
+			}
+		case isNameChar(c) || c == '.':
+			t.pos++
+		default:
+			return
+		}
+	}
+}
+
+// Reports whether a string literal, with a prefix, starts at the current
+// position.
+func (t *tokenizer) stringPrefix() bool {
+	for i := t.pos; i < len(t.src) && i-t.pos <= 2; i++ {
+		switch t.src[i] {
+		case 'r', 'R', 'b', 'B', 'u', 'U', 'f', 'F', 't', 'T':
+			continue
+		case '"', '\'':
+			return i > t.pos
+		}
+		return false
+	}
+	return false
+}
+
+// Scans a string literal, including its prefix, starting at the current
+// position.
+func (t *tokenizer) scanString() error {
+	prefix := t.pos
+	for t.src[t.pos] != '"' && t.src[t.pos] != '\'' {
+		t.pos++
+	}
+	formatted := strings.ContainsAny(t.src[prefix:t.pos], "fFtT")
+	raw := strings.ContainsAny(t.src[prefix:t.pos], "rR")
+	quote := t.src[t.pos : t.pos+1]
+	if strings.HasPrefix(t.src[t.pos:], quote+quote+quote) {
+		quote += quote + quote
+	}
+	t.pos += len(quote)
+	for {
+		if t.pos >= len(t.src) {
+			return fmt.Errorf("unterminated string literal")
+		}
+		c := t.src[t.pos]
+		switch {
+		case strings.HasPrefix(t.src[t.pos:], quote):
+			t.pos += len(quote)
+			return nil
+		case c == '\\':
+			// Only skip the escaped characters which could otherwise end the
+			// string; braces in f-strings can not be escaped this way.
+			t.pos++
+			if t.pos >= len(t.src) {
+				continue
+			}
+			switch t.src[t.pos] {
+			case '\n', '\r':
+				t.newline()
+			case '\\', quote[0]:
+				t.pos++
+			case 'N':
+				if formatted && !raw && strings.HasPrefix(t.src[t.pos+1:], "{") {
+					if end := strings.IndexByte(t.src[t.pos:], '}'); end >= 0 {
+						t.pos += end + 1
+					}
+				}
+			}
+		case c == '\n' || c == '\r':
+			if len(quote) == 1 {
+				return fmt.Errorf("unterminated string literal")
+			}
+			t.newline()
+		case formatted && (c == '{' || c == '}'):
+			if t.pos+1 < len(t.src) && t.src[t.pos+1] == c {
+				t.pos += 2
+				continue
+			}
+			t.pos++
+			if c == '{' {
+				if err := t.scanReplacementField(); err != nil {
+					return err
+				}
+			}
+		default:
+			t.pos++
+		}
+	}
+}
+
+// Scans the expression part of a replacement field in an f-string, up to and
+// including the closing brace. Nested strings may use any quotes.
+func (t *tokenizer) scanReplacementField() error {
+	depth := 0
+	for {
+		if t.pos >= len(t.src) {
+			return fmt.Errorf("unterminated f-string")
+		}
+		c := t.src[t.pos]
+		switch {
+		case c == '"' || c == '\'' || (isNameStart(c) && t.stringPrefix()):
+			if err := t.scanString(); err != nil {
+				return err
+			}
+		case isNameStart(c):
+			for t.pos < len(t.src) && isNameChar(t.src[t.pos]) {
+				t.pos++
+			}
+		case c == '(' || c == '[' || c == '{':
+			depth++
+			t.pos++
+		case c == ')' || c == ']':
+			depth--
+			t.pos++
+		case c == '}':
+			t.pos++
+			if depth == 0 {
+				return nil
+			}
+			depth--
+		case c == ':' && depth == 0:
+			t.pos++
+			return t.scanFormatSpec()
+		case c == '#':
+			t.skipComment()
+		case c == '\n' || c == '\r':
+			t.newline()
+		default:
+			t.pos++
+		}
+	}
+}
+
+// Scans the format specifier of a replacement field in an f-string, up to and
+// including the closing brace of the field.
+func (t *tokenizer) scanFormatSpec() error {
+	for {
+		if t.pos >= len(t.src) {
+			return fmt.Errorf("unterminated f-string")
+		}
+		switch c := t.src[t.pos]; c {
+		case '{':
+			t.pos++
+			if err := t.scanReplacementField(); err != nil {
+				return err
+			}
+		case '}':
+			t.pos++
+			return nil
+		case '\n', '\r':
+			t.newline()
+		default:
+			t.pos++
+		}
+	}
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c >= 0x80
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// Returns the value of a string literal token if it is a plain (not bytes or
+// formatted) string, with the common escape sequences interpreted.
+func stringValue(tok token) (string, bool) {
+	if tok.kind != tokenString {
+		return "", false
+	}
+	text := tok.text
+	i := strings.IndexAny(text, "\"'")
+	prefix := strings.ToLower(text[:i])
+	if strings.ContainsAny(prefix, "bft") {
+		return "", false
+	}
+	quote := text[i : i+1]
+	if strings.HasPrefix(text[i:], quote+quote+quote) && len(text)-i >= 6 {
+		quote += quote + quote
+	}
+	body := text[i+len(quote) : len(text)-len(quote)]
+	if strings.Contains(prefix, "r") || !strings.Contains(body, "\\") {
+		return body, true
+	}
+	var sb strings.Builder
+	for j := 0; j < len(body); j++ {
+		if body[j] != '\\' || j+1 == len(body) {
+			sb.WriteByte(body[j])
+			continue
+		}
+		j++
+		switch c := body[j]; c {
+		case '\n':
+		case '\\', '\'', '"':
+			sb.WriteByte(c)
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		default:
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), true
+}
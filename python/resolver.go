@@ -16,7 +16,9 @@ package python
 
 import (
 	"log"
+	"math"
 	"path"
+	"sort"
 	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
@@ -24,10 +26,63 @@ import (
 	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/resolve"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
 	"github.com/siddharthab/bazel-gazelle-python/internal"
+	"github.com/siddharthab/bazel-gazelle-python/python/parser"
 )
 
-type Resolver struct{}
+// Name of the macro which gives the label for an external distribution.
+const requirementMacro = "requirement"
+
+type Resolver struct {
+	// Python modules of all generated rules, keyed by import specifier. Used to
+	// check the symbols defined by a module when resolving imports.
+	modules map[string]*Module
+	// BUILD files of the generated rules, keyed by Bazel package path, as seen
+	// when generating or indexing the rules. Used to merge deps into the
+	// existing rules and to add the load for requirement() calls.
+	files map[string]*rule.File
+	// Implicit namespace packages (PEP 420) by import specifier, which have
+	// no rules of their own.
+	namespaces map[string]struct{}
+	// Python roots of the Bazel packages with rules, keyed by package path.
+	roots map[string]pythonRoot
+	// Root of the rules which provide each module, keyed by import specifier,
+	// to warn about modules shadowed by roots of higher priority.
+	moduleRoots map[string]pythonRoot
+	// Number of generated rules yet to be resolved. Checks across all rules
+	// are done once it reaches zero, as there is no other hook at the end of
+	// the resolution phase. Gazelle resolves each generated rule exactly once,
+	// after generating all of them. Without generated rules, there is nothing
+	// to check, and the report of unresolved imports is the empty one written
+	// by CheckFlags.
+	pending *int
+	// Imports which could not be resolved, reported after all generated rules
+	// are resolved.
+	unresolved *unresolvedImports
+	// External dependency hubs of the generated rules, checked after all
+	// generated rules are resolved.
+	hubs hubDeps
+}
+
+// pythonRoot is a root directory for Python code, with its priority; lower
+// values have higher priority.
+type pythonRoot struct {
+	dir      string
+	priority int
+}
+
+// dependency is a resolved dependency of a rule; either a Bazel label, or the
+// name of an external distribution for a requirement() call, with the label to
+// use if the load for requirement() can not be added. Both are empty for
+// internal modules.
+type dependency struct {
+	Label       string
+	Requirement string
+	// Path of the external module map which provides the dependency, if any;
+	// cleared when collecting the dependencies of a rule.
+	Hub string
+}
 
 var _ resolve.Resolver = (*Resolver)(nil)
 
@@ -38,8 +93,12 @@ func (pr Resolver) Name() string {
 
 // Imports implements resolve.Resolver.
 //
-// Returns all Python module import specs defined by the files in "srcs" attribute.
+// Returns all Python module import specs defined by the files in "srcs" attribute,
+// except for binary modules which are provided by the rule for their cycle.
 func (pr Resolver) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	if f != nil {
+		pr.files[f.Pkg] = f
+	}
 	kind := r.Kind()
 	if kind != kindPyLibrary && kind != kindPyBinary {
 		return nil
@@ -52,20 +111,51 @@ func (pr Resolver) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resol
 		return nil
 	}
 
+	root := pythonRoot{dir: config.RootDir, priority: config.rootPriority()}
+	if f != nil {
+		pr.roots[f.Pkg] = root
+	}
+
 	var res []resolve.ImportSpec
 	for _, src := range r.AttrStrings("srcs") {
 		moduleName, _, ok := internal.ModuleName(src)
 		if !ok {
 			continue
 		}
+		imp := internal.ImportSpec(pkgPath, moduleName)
+		if module, ok := pr.modules[imp]; ok && module.ruleOwner() != module && kind != kindPyLibrary {
+			// Binary in a cycle; the rule for the cycle provides the module.
+			continue
+		}
+		pr.checkShadowing(imp, root)
 		res = append(res, resolve.ImportSpec{
 			Lang: languageName,
-			Imp:  internal.ImportSpec(pkgPath, moduleName),
+			Imp:  imp,
 		})
 	}
 	return res
 }
 
+// Records the root which provides the module, and warns if the module is also
+// provided by another root.
+func (pr Resolver) checkShadowing(imp string, root pythonRoot) {
+	prev, ok := pr.moduleRoots[imp]
+	if !ok {
+		pr.moduleRoots[imp] = root
+		return
+	}
+	switch {
+	case prev.dir == root.dir:
+	case prev.priority == root.priority:
+		log.Printf("Python module %q is provided by roots %q and %q of the same priority", imp, prev.dir, root.dir)
+	case prev.priority < root.priority:
+		log.Printf("Python module %q in root %q is shadowed by root %q", imp, root.dir, prev.dir)
+	default:
+		log.Printf("Python module %q in root %q is shadowed by root %q", imp, prev.dir, root.dir)
+		pr.moduleRoots[imp] = root
+	}
+}
+
 // Embeds implements resolve.Resolver.
 func (pr Resolver) Embeds(r *rule.Rule, from label.Label) []label.Label {
 	return nil
@@ -75,82 +165,487 @@ func (pr Resolver) Embeds(r *rule.Rule, from label.Label) []label.Label {
 func (pr Resolver) Resolve(c *config.Config, ix *resolve.RuleIndex, _ *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label) {
 	config := c.Exts[languageName].(Configuration)
 	module := imports.(*Module)
-	deps := make(map[string]struct{})
-	for _, imp := range transitiveImports(module) {
-		target, ok := findRuleByImportFuzzy(imp, ix, config.ExternalModuleMap, config.InternalModuleList)
-		if target != "" {
-			deps[target] = struct{}{}
-			continue
+	defer pr.ruleResolved(&config)
+	srcModules := module.srcModules()
+	deps := make(map[dependency]struct{})
+	selects := make(conditionalDeps)
+	addDep := func(dep dependency, cond string) {
+		if setting, negated, ok := config.conditionSetting(cond); ok {
+			selects.add(setting, negated, dep)
+		} else {
+			deps[dep] = struct{}{}
 		}
-		if !ok {
-			log.Printf("could not find Bazel rule for import %q", imp)
+	}
+	var hub string
+	for _, srcModule := range srcModules {
+		for _, imp := range srcModule.ExPkgImports {
+			if len(imp.Deps) > 0 {
+				for _, dep := range annotatedDeps(imp, from) {
+					addDep(dep, imp.Condition)
+				}
+				continue
+			}
+			if imp.Optional && config.OptionalImports == OptionalImportsDrop {
+				if config.Debug {
+					log.Printf("dropped optional import %q in %s", imp.Name, from)
+				}
+				continue
+			}
+			dep, prefix, ok := pr.findRuleByImportPrefix(imp.Name, ix, &config)
+			if ok && config.Debug {
+				log.Printf("resolved import %q in %s with prefix %q", imp.Name, from, prefix)
+			}
+			if dep.Hub != "" {
+				hub, dep.Hub = dep.Hub, ""
+			}
+			if dep != (dependency{}) {
+				addDep(dep, imp.Condition)
+				continue
+			}
+			if !ok && imp.Optional && config.OptionalImports == OptionalImportsIfResolvable {
+				if config.Debug {
+					log.Printf("ignored unresolved optional import %q in %s", imp.Name, from)
+				}
+				continue
+			}
+			if !ok {
+				if imp.Dynamic {
+					log.Printf("could not find Bazel rule for dynamic import %q", imp.Name)
+				} else {
+					log.Printf("could not find Bazel rule for import %q", imp.Name)
+				}
+				pr.addUnresolved(&config, srcModule, imp, from)
+			}
 		}
 	}
-	// Depend on parent package for module initialization.
-	imp := module.ImportSpec
+	// Depend on the rules for sibling modules.
+	for _, dep := range siblingDeps(srcModules) {
+		name := dep.ruleOwner().ruleName(config.NameTemplate)
+		deps[dependency{Label: label.New(from.Repo, from.Pkg, name).String()}] = struct{}{}
+	}
+	// Depend on parent package for module initialization. Namespace packages
+	// have no rule, and are skipped for their parent package.
+	imp, dir := module.ImportSpec, from.Pkg
+	if module.Name == "" {
+		// The package __init__.py depends on its parent package.
+		dir = parentDir(dir)
+	}
 	ext := path.Ext(imp)
 	for ext != "" {
 		imp = strings.TrimSuffix(imp, ext)
 		ext = path.Ext(imp)
-		if target, ok := findRuleByImport(imp, ix, nil, nil); ok && target != "" {
-			deps[target] = struct{}{}
+		if dep, ok := findPackageRule(imp, dir, ix); ok {
+			deps[dep] = struct{}{}
 			break
 		}
+		dir = parentDir(dir)
+	}
+
+	// Modules in a cycle with the package __init__.py find their own rule.
+	delete(deps, dependency{Label: from.String()})
+
+	// Apply the overrides from directives.
+	if ruleDeps, ok := config.RuleDeps[r.Name()]; ok {
+		for _, l := range ruleDeps.Add {
+			deps[dependency{Label: l.Abs(from.Repo, from.Pkg).String()}] = struct{}{}
+		}
+		for _, l := range ruleDeps.Remove {
+			delete(deps, dependency{Label: l.Abs(from.Repo, from.Pkg).String()})
+			selects.delete(dependency{Label: l.Abs(from.Repo, from.Pkg).String()})
+		}
+	}
+	// Conditional dependencies which are also unconditional are redundant.
+	selects.delete(dependency{Label: from.String()})
+	for dep := range deps {
+		selects.delete(dep)
+	}
+
+	pr.addHubDeps(from, hub, selects.union(deps))
+	if len(selects) == 0 {
+		pr.setDeps(r, rule.ExprFromValue(pr.depsAttrValue(deps, &config, from)), from)
+	} else {
+		pr.setDeps(r, pr.conditionalDepsExpr(deps, selects, &config, from), from)
+	}
+	if config.TypeOnlyDepsAttr != "" {
+		pr.resolveTypeOnlyDeps(ix, r, srcModules, deps, &config, from)
+	}
+}
+
+// Returns the dependencies given by the `# gazelle:dep` annotations of the
+// import, with labels relative to the package of the rule.
+func annotatedDeps(imp parser.Import, from label.Label) []dependency {
+	var res []dependency
+	for _, dep := range imp.Deps {
+		l, err := label.Parse(dep)
+		if err != nil {
+			log.Printf("invalid label %q in annotation of import %q in %s: %v", dep, imp.Name, from, err)
+			continue
+		}
+		res = append(res, dependency{Label: l.Abs(from.Repo, from.Pkg).String()})
+	}
+	return res
+}
+
+// Sets the dependencies on the generated rule, and merges them into the
+// existing rule in the BUILD file. Gazelle does not merge deps as a resolved
+// attribute (see kinds), because it cannot merge select() expressions keyed by
+// settings other than Go platforms. Lists are merged as Gazelle would, keeping
+// the entries marked with "# keep"; select() expressions are replaced.
+func (pr Resolver) setDeps(r *rule.Rule, expr bzl.Expr, from label.Label) {
+	if l, ok := expr.(*bzl.ListExpr); ok && len(l.List) == 0 {
+		r.DelAttr("deps")
+	} else {
+		r.SetAttr("deps", expr)
+	}
+	for _, existing := range pr.existingRules(r, from) {
+		if existing.ShouldKeep() || (existing.Attr("deps") != nil && rule.ShouldKeep(existing.Attr("deps"))) {
+			continue
+		}
+		if isListOrNil(existing.Attr("deps")) && isListOrNil(r.Attr("deps")) {
+			src := rule.NewRule(r.Kind(), r.Name())
+			if r.Attr("deps") != nil {
+				src.SetAttr("deps", r.Attr("deps"))
+			}
+			rule.MergeRules(src, existing, map[string]bool{"deps": true}, pr.files[from.Pkg].Path)
+		} else if r.Attr("deps") == nil {
+			existing.DelAttr("deps")
+		} else {
+			existing.SetAttr("deps", r.Attr("deps"))
+		}
+	}
+}
+
+func isListOrNil(expr bzl.Expr) bool {
+	_, ok := expr.(*bzl.ListExpr)
+	return ok || expr == nil
+}
+
+// Returns the rules in the BUILD file which the generated rule is merged with,
+// other than the generated rule itself.
+func (pr Resolver) existingRules(r *rule.Rule, from label.Label) []*rule.Rule {
+	var rules []*rule.Rule
+	if f, ok := pr.files[from.Pkg]; ok {
+		for _, existing := range f.Rules {
+			if existing.Name() == r.Name() && existing != r {
+				rules = append(rules, existing)
+			}
+		}
+	}
+	return rules
+}
+
+// Sets the attribute for type-only dependencies on the rule, both on the
+// generated rule and on the existing rule in the BUILD file, as the attribute
+// is not known to be a resolved attribute when merging them. Dependencies
+// which are also needed at run time are omitted.
+func (pr Resolver) resolveTypeOnlyDeps(ix *resolve.RuleIndex, r *rule.Rule, srcModules []*Module, deps map[dependency]struct{}, config *Configuration, from label.Label) {
+	typeDeps := make(map[dependency]struct{})
+	for _, srcModule := range srcModules {
+		for _, imp := range srcModule.AbsTypeOnlyImports {
+			if len(imp.Deps) > 0 {
+				for _, dep := range annotatedDeps(imp, from) {
+					if _, ok := deps[dep]; !ok {
+						typeDeps[dep] = struct{}{}
+					}
+				}
+				continue
+			}
+			dep, _, ok := pr.findRuleByImportPrefix(imp.Name, ix, config)
+			if !ok {
+				log.Printf("could not find Bazel rule for type-only import %q", imp.Name)
+				continue
+			}
+			dep.Hub = ""
+			if _, ok := deps[dep]; !ok && dep != (dependency{}) {
+				typeDeps[dep] = struct{}{}
+			}
+		}
+	}
+	delete(typeDeps, dependency{Label: from.String()})
+	for _, r := range append([]*rule.Rule{r}, pr.existingRules(r, from)...) {
+		if len(typeDeps) == 0 {
+			r.DelAttr(config.TypeOnlyDepsAttr)
+		} else {
+			r.SetAttr(config.TypeOnlyDepsAttr, pr.depsAttrValue(typeDeps, config, from))
+		}
+	}
+}
+
+// Returns the expression for the dependencies, followed by a select() for the
+// dependencies under the condition of each setting, with those under the
+// negated condition in the default branch.
+func (pr Resolver) conditionalDepsExpr(deps map[dependency]struct{}, selects conditionalDeps, config *Configuration, from label.Label) bzl.Expr {
+	var expr bzl.Expr
+	if len(deps) > 0 {
+		expr = rule.ExprFromValue(pr.depsAttrValue(deps, config, from))
+	}
+	var settings []string
+	for setting := range selects {
+		settings = append(settings, setting)
+	}
+	sort.Strings(settings)
+	for _, setting := range settings {
+		matched := rule.ExprFromValue(pr.depsAttrValue(selects[setting].matched, config, from))
+		matched.(*bzl.ListExpr).ForceMultiLine = len(selects[setting].matched) > 0
+		unmatched := rule.ExprFromValue(pr.depsAttrValue(selects[setting].unmatched, config, from))
+		sel := &bzl.CallExpr{
+			X: &bzl.Ident{Name: "select"},
+			List: []bzl.Expr{&bzl.DictExpr{
+				List: []bzl.Expr{
+					&bzl.KeyValueExpr{Key: &bzl.StringExpr{Value: setting}, Value: matched},
+					&bzl.KeyValueExpr{Key: &bzl.StringExpr{Value: conditionDefault}, Value: unmatched},
+				},
+				ForceMultiLine: true,
+			}},
+		}
+		if expr == nil {
+			expr = sel
+		} else {
+			expr = &bzl.BinaryExpr{X: expr, Op: "+", Y: sel}
+		}
 	}
+	return expr
+}
 
-	// Set the attribute on the rule.
-	var depsAttr []string
+// Returns the value of an attribute for the dependencies: a list of labels,
+// followed by requirement() calls for external distributions if any. The load
+// for requirement() is then added to the BUILD file.
+func (pr Resolver) depsAttrValue(deps map[dependency]struct{}, config *Configuration, from label.Label) interface{} {
+	// New BUILD files are only known when indexing (see GenerateRules), and
+	// without the file, labels are used instead of requirement() calls.
+	f, hasFile := pr.files[from.Pkg]
+	var labels, requirements []string
+	var fallback bool
 	for dep := range deps {
-		depsAttr = append(depsAttr, dep)
+		if dep.Requirement != "" && hasFile {
+			requirements = append(requirements, dep.Requirement)
+		} else {
+			labels = append(labels, dep.Label)
+			fallback = fallback || dep.Requirement != ""
+		}
+	}
+	if fallback {
+		log.Printf("could not add load for %s() calls to the new BUILD file in package %q, as indexing is disabled; using labels instead", requirementMacro, from.Pkg)
 	}
-	r.SetAttr("deps", depsAttr)
+	sort.Strings(labels)
+	if len(requirements) == 0 {
+		return labels
+	}
+	sort.Strings(requirements)
+	depsExpr := &bzl.ListExpr{ForceMultiLine: len(labels)+len(requirements) > 1}
+	for _, l := range labels {
+		depsExpr.List = append(depsExpr.List, &bzl.StringExpr{Value: l})
+	}
+	for _, dist := range requirements {
+		depsExpr.List = append(depsExpr.List, &bzl.CallExpr{
+			X:    &bzl.Ident{Name: requirementMacro},
+			List: []bzl.Expr{&bzl.StringExpr{Value: dist}},
+		})
+	}
+	addRequirementLoad(f, config.RequirementLoad)
+	return depsExpr
 }
 
-// Extract all ExPkgImports from modules and its sibling dependencies.
-func transitiveImports(module *Module) []string {
-	modules := []*Module{module}
-	for dep := range module.InPkgDeps {
-		modules = append(modules, dep)
+// Records the hub of the external dependencies of the rule, and its other
+// dependencies.
+func (pr Resolver) addHubDeps(from label.Label, hub string, deps map[dependency]struct{}) {
+	r := hubRule{hub: hub}
+	for dep := range deps {
+		if dep.Label != "" {
+			r.deps = append(r.deps, dep.Label)
+		}
 	}
-	var res []string
+	pr.hubs[from.String()] = r
+}
+
+// Records that a generated rule has been resolved. After the last one, reports
+// the unresolved imports, and exits if any rule depends on more than one
+// external dependency hub.
+func (pr Resolver) ruleResolved(config *Configuration) {
+	*pr.pending--
+	if *pr.pending < 0 {
+		log.Panicf("bug: more Python rules resolved than generated")
+	}
+	if *pr.pending > 0 {
+		return
+	}
+	conflicts := pr.hubs.conflicts()
+	var targets []string
+	for target := range conflicts {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		log.Printf("%s depends on external modules from more than one hub: %q", target, conflicts[target])
+	}
+	pr.unresolved.report(config.UnresolvedReportPath)
+	if len(targets) > 0 {
+		log.Fatalf("%d Python rules depend on external modules from more than one hub; no BUILD files were written", len(targets))
+	}
+}
+
+// Adds the load for the requirement macro to the file, if not already loaded.
+func addRequirementLoad(f *rule.File, file string) {
+	var existing *rule.Load
+	for _, l := range f.Loads {
+		if l.Has(requirementMacro) {
+			return
+		}
+		if l.Name() == file {
+			existing = l
+		}
+	}
+	if existing != nil {
+		existing.Add(requirementMacro)
+		return
+	}
+	// Sync the file first so that the load is placed before any rules which
+	// are yet to be inserted in a new file.
+	f.Sync()
+	l := rule.NewLoad(file)
+	l.Add(requirementMacro)
+	l.Insert(f, 0)
+}
+
+// Records an import which could not be resolved, if it needs to be reported.
+func (pr Resolver) addUnresolved(config *Configuration, module *Module, imp parser.Import, from label.Label) {
+	if !config.Strict && config.UnresolvedReportPath == "" {
+		return
+	}
+	unresolved := unresolvedImport{
+		File:    path.Join(from.Pkg, module.Filename),
+		Line:    imp.Line,
+		Import:  imp.Name,
+		Target:  from.String(),
+		Dynamic: imp.Dynamic,
+		strict:  config.Strict && !imp.Dynamic,
+	}
+	if config.UnresolvedReportPath != "" {
+		unresolved.Suggestions = suggestImports(imp.Name, pr.modules, config.ExternalModuleMap, config.StdlibModuleList, config.InternalModuleList)
+	}
+	pr.unresolved.imports = append(pr.unresolved.imports, unresolved)
+}
+
+// Returns the parent directory of the slash separated path, or "" for the
+// repository root.
+func parentDir(dir string) string {
+	if parent := path.Dir(dir); parent != "." {
+		return parent
+	}
+	return ""
+}
+
+// Finds the rule for the __init__.py of the package in the Bazel package at
+// dir. Rules for packages of the same name in other Python roots are ignored,
+// as the package may be split across roots as a namespace package.
+func findPackageRule(imp, dir string, ix *resolve.RuleIndex) (dependency, bool) {
+	for _, res := range ix.FindRulesByImport(resolve.ImportSpec{Lang: languageName, Imp: imp}, languageName) {
+		if res.Label.Pkg == dir {
+			return dependency{Label: res.Label.String()}, true
+		}
+	}
+	return dependency{}, false
+}
+
+// Extract the InPkgDeps of the modules, other than the modules themselves.
+func siblingDeps(modules []*Module) []*Module {
+	self := make(map[*Module]struct{})
+	for _, module := range modules {
+		self[module] = struct{}{}
+	}
+	var res []*Module
 	for _, module := range modules {
-		res = append(res, module.ExPkgImports...)
+		for dep := range module.InPkgDeps {
+			if _, ok := self[dep]; !ok {
+				res = append(res, dep)
+			}
+		}
 	}
 	return res
 }
 
-func findRuleByImportFuzzy(imp string, ix *resolve.RuleIndex, externalModuleMap map[string]ExternalModule, internalModuleList map[string]struct{}) (string, bool) {
-	// Check exact matches.
-	if target, ok := findRuleByImport(imp, ix, externalModuleMap, internalModuleList); ok {
-		return target, ok
-	}
-	// Check exact matches for parent import, in case the import specifier is for a symbol.
-	ext := path.Ext(imp)
-	if ext == "" {
-		return "", false
+// Finds the rule for the import, or else for the longest prefix of the import
+// which has one, as the import specifier may be for a symbol in a module, or a
+// module in an external distribution or the standard library which is only
+// known by its package. Returns the matched prefix.
+func (pr Resolver) findRuleByImportPrefix(imp string, ix *resolve.RuleIndex, config *Configuration) (dependency, string, bool) {
+	prefix := imp
+	for {
+		if dep, ok := pr.findRuleByImport(prefix, ix, config); ok {
+			return dep, prefix, true
+		}
+		if _, ok := pr.namespaces[prefix]; ok {
+			// Namespace packages need no dependency, and define no symbols.
+			return dependency{}, prefix, prefix == imp
+		}
+		i := strings.LastIndexByte(prefix, '.')
+		if i < 0 {
+			return dependency{}, "", false
+		}
+		parent, name := prefix[:i], prefix[i+1:]
+		if module, ok := pr.modules[parent]; ok && !module.DefinesSymbol(name) {
+			// We parsed the parent module, and it neither defines the symbol
+			// nor has the submodule.
+			return dependency{}, "", false
+		}
+		prefix = parent
 	}
-	return findRuleByImport(strings.TrimSuffix(imp, ext), ix, externalModuleMap, internalModuleList)
 }
 
-func findRuleByImport(imp string, ix *resolve.RuleIndex, externalModuleMap map[string]ExternalModule, internalModuleList map[string]struct{}) (string, bool) {
+// Finds the rule for the import in the index, and then in the external module
+// map and the internal module list from the config, if given. Rules in Python
+// roots of higher priority are preferred.
+func (pr Resolver) findRuleByImport(imp string, ix *resolve.RuleIndex, config *Configuration) (dependency, bool) {
 	results := ix.FindRulesByImport(resolve.ImportSpec{Lang: languageName, Imp: imp}, languageName)
+	if len(results) > 1 {
+		priority := func(i int) int {
+			if root, ok := pr.roots[results[i].Label.Pkg]; ok {
+				return root.priority
+			}
+			return math.MaxInt
+		}
+		sort.SliceStable(results, func(i, j int) bool { return priority(i) < priority(j) })
+		best := priority(0)
+		for i := range results {
+			if priority(i) != best {
+				results = results[:i]
+				break
+			}
+		}
+	}
 	for _, res := range results {
 		if res.Label.Name == path.Base(res.Label.Pkg) {
-			return res.Label.String(), true
+			return dependency{Label: res.Label.String()}, true
 		}
 	}
 	if len(results) > 0 {
-		return results[0].Label.String(), true
+		return dependency{Label: results[0].Label.String()}, true
 	}
-	if externalModuleMap != nil {
-		if dep, ok := externalModuleMap[imp]; ok {
-			return dep.BazelTarget, true
-		}
+	if config == nil {
+		return dependency{}, false
 	}
-	if internalModuleList != nil {
-		if _, ok := internalModuleList[imp]; ok {
-			return "", true
+	if dep, ok := config.ExternalModuleMap[imp]; ok {
+		if len(dep.OtherProviders) > 0 {
+			provider, ok := dep.provider(config.ExternalModuleProviders[imp])
+			if !ok {
+				if !dep.Namespace {
+					log.Printf("Python module %q is provided by conflicting distributions %q; choose one with the %q directive", imp, dep.dists(), directiveExternalModuleProvider)
+				}
+				return dependency{}, false
+			}
+			dep = provider
 		}
+		hub := config.ExternalModuleMapPath
+		if target, ok := config.DistributionLabels[dep.Dist]; ok {
+			return dependency{Label: target, Hub: hub}, true
+		}
+		if config.RequirementLoad != "" {
+			return dependency{Label: dep.BazelTarget, Requirement: dep.Dist, Hub: hub}, true
+		}
+		return dependency{Label: dep.BazelTarget, Hub: hub}, true
+	}
+	if config.isInternalModule(imp) {
+		return dependency{}, true
 	}
-	return "", false
+	return dependency{}, false
 }
@@ -0,0 +1,196 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package python
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+// Detects the Python roots of a project in the directory rel, from the
+// packaging configuration in pyproject.toml (setuptools, hatch or poetry) or
+// setup.cfg. If the project does not configure its packages, the roots are
+// src for a src layout, or the project directory itself. Returns nil if the
+// directory does not have a Python project, i.e. no setup.py and no packaging
+// metadata in pyproject.toml or setup.cfg; files that only configure tools,
+// like a pyproject.toml with just [tool.black], do not make a project.
+func detectRoots(repoRoot, rel string) ([]string, error) {
+	dir := filepath.Join(repoRoot, filepath.FromSlash(rel))
+	var roots []string
+	var isProject bool
+	if content, err := os.ReadFile(filepath.Join(dir, "pyproject.toml")); err == nil {
+		if roots, isProject, err = pyprojectRoots(content); err != nil {
+			return nil, fmt.Errorf("reading Python roots from %q: %w", path.Join(rel, "pyproject.toml"), err)
+		}
+	}
+	if f, err := os.Open(filepath.Join(dir, "setup.cfg")); err == nil {
+		cfgRoots, cfgIsProject, err := setupCfgRoots(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading Python roots from %q: %w", path.Join(rel, "setup.cfg"), err)
+		}
+		if len(roots) == 0 {
+			roots = cfgRoots
+		}
+		isProject = isProject || cfgIsProject
+	}
+	if _, err := os.Stat(filepath.Join(dir, "setup.py")); err == nil {
+		isProject = true
+	}
+	if !isProject {
+		return nil, nil
+	}
+	if len(roots) == 0 {
+		roots = []string{"."}
+		if info, err := os.Stat(filepath.Join(dir, "src")); err == nil && info.IsDir() {
+			roots = []string{"src"}
+		}
+	}
+	var res []string
+	for _, root := range roots {
+		res = append(res, path.Join(rel, root))
+	}
+	return res, nil
+}
+
+// Returns the roots configured in pyproject.toml, relative to its directory,
+// and whether it has packaging metadata, i.e. a [project] or [build-system]
+// table, or the configuration of setuptools, poetry or hatch.
+func pyprojectRoots(content []byte) ([]string, bool, error) {
+	tree, err := toml.LoadBytes(content)
+	if err != nil {
+		return nil, false, err
+	}
+	isProject := tree.Has("project") || tree.Has("build-system") ||
+		tree.Has("tool.setuptools") || tree.Has("tool.poetry") || tree.Has("tool.hatch")
+	if !isProject {
+		return nil, false, nil
+	}
+	// setuptools: package-dir = {"" = "src"}, or packages.find.where.
+	if packageDir, ok := tree.GetPath([]string{"tool", "setuptools", "package-dir"}).(*toml.Tree); ok {
+		if root, ok := packageDir.GetPath([]string{""}).(string); ok {
+			return []string{root}, true, nil
+		}
+	}
+	if where := stringValues(tree.GetPath([]string{"tool", "setuptools", "packages", "find", "where"})); len(where) > 0 {
+		return where, true, nil
+	}
+	// hatch: paths of the packages in the wheel, e.g. "src/foo".
+	if packages := stringValues(tree.GetPath([]string{"tool", "hatch", "build", "targets", "wheel", "packages"})); len(packages) > 0 {
+		var roots []string
+		for _, pkg := range packages {
+			roots = append(roots, path.Dir(path.Clean(pkg)))
+		}
+		return uniqueStrings(roots), true, nil
+	}
+	// poetry: packages = [{include = "foo", from = "src"}].
+	if packages, ok := tree.GetPath([]string{"tool", "poetry", "packages"}).([]*toml.Tree); ok {
+		var roots []string
+		for _, pkg := range packages {
+			from, _ := pkg.Get("from").(string)
+			roots = append(roots, path.Clean(from))
+		}
+		return uniqueStrings(roots), true, nil
+	}
+	return nil, true, nil
+}
+
+// Returns the string values of a TOML string or array of strings.
+func stringValues(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var res []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+// Returns the unique values, in order.
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]struct{})
+	var res []string
+	for _, value := range values {
+		if _, ok := seen[value]; !ok {
+			seen[value] = struct{}{}
+			res = append(res, value)
+		}
+	}
+	return res
+}
+
+// Returns the roots configured in setup.cfg, relative to its directory, from
+// options.package_dir or options.packages.find.where, and whether it has
+// packaging metadata, i.e. a [metadata] or [options] section.
+func setupCfgRoots(r io.Reader) ([]string, bool, error) {
+	values := make(map[string]string) // Keyed by section and option.
+	var section, key string
+	var isProject bool
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";"):
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			section, key = strings.TrimSpace(trimmed[1:len(trimmed)-1]), ""
+			if section == "metadata" || section == "options" || strings.HasPrefix(section, "options.") {
+				isProject = true
+			}
+		case line[0] == ' ' || line[0] == '\t':
+			// Continuation of a multi-line value.
+			if key != "" {
+				values[key] += "\n" + trimmed
+			}
+		default:
+			name, value, ok := strings.Cut(trimmed, "=")
+			if !ok {
+				name, value, ok = strings.Cut(trimmed, ":")
+			}
+			if !ok {
+				key = ""
+				continue
+			}
+			key = section + "." + strings.TrimSpace(name)
+			values[key] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, err
+	}
+	// package_dir has lines like "=src", or "pkg=lib/pkg" for single packages.
+	for _, line := range strings.Split(values["options.package_dir"], "\n") {
+		if name, dir, ok := strings.Cut(line, "="); ok && strings.TrimSpace(name) == "" {
+			return []string{strings.TrimSpace(dir)}, isProject, nil
+		}
+	}
+	if where := strings.Fields(values["options.packages.find.where"]); len(where) > 0 {
+		return where, isProject, nil
+	}
+	return nil, isProject, nil
+}
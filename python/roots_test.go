@@ -0,0 +1,218 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package python
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPyprojectRoots(t *testing.T) {
+	testCases := []struct {
+		content       string
+		want          []string
+		wantIsProject bool
+	}{
+		{
+			content: `[project]
+name = "foo"
+`,
+			wantIsProject: true,
+		},
+		{
+			content: `[build-system]
+requires = ["flit_core"]
+`,
+			wantIsProject: true,
+		},
+		{
+			content: `[tool.black]
+line-length = 100
+`,
+		},
+		{
+			content: `[tool.setuptools]
+package-dir = {"" = "src"}
+`,
+			want:          []string{"src"},
+			wantIsProject: true,
+		},
+		{
+			content: `[tool.setuptools.packages.find]
+where = ["lib", "plugins"]
+`,
+			want:          []string{"lib", "plugins"},
+			wantIsProject: true,
+		},
+		{
+			content: `[tool.hatch.build.targets.wheel]
+packages = ["src/foo", "src/bar", "baz"]
+`,
+			want:          []string{"src", "."},
+			wantIsProject: true,
+		},
+		{
+			content: `[tool.poetry]
+packages = [
+    { include = "foo", from = "lib" },
+    { include = "bar" },
+]
+`,
+			want:          []string{"lib", "."},
+			wantIsProject: true,
+		},
+	}
+	for i, tc := range testCases {
+		got, isProject, err := pyprojectRoots([]byte(tc.content))
+		if err != nil {
+			t.Errorf("test %d: %v", i, err)
+			continue
+		}
+		if diff := cmp.Diff(got, tc.want); diff != "" {
+			t.Errorf("test %d: (-got, +want):%s", i, diff)
+		}
+		if isProject != tc.wantIsProject {
+			t.Errorf("test %d: got isProject %t, want %t", i, isProject, tc.wantIsProject)
+		}
+	}
+}
+
+func TestSetupCfgRoots(t *testing.T) {
+	testCases := []struct {
+		content       string
+		want          []string
+		wantIsProject bool
+	}{
+		{
+			content: `[metadata]
+name = foo
+`,
+			wantIsProject: true,
+		},
+		{
+			content: `[flake8]
+max-line-length = 100
+`,
+		},
+		{
+			content: `[options]
+package_dir =
+    =src
+packages = find:
+`,
+			want:          []string{"src"},
+			wantIsProject: true,
+		},
+		{
+			content: `[options]
+package_dir =
+    foo = lib/foo
+
+[options.packages.find]
+where = lib
+`,
+			want:          []string{"lib"},
+			wantIsProject: true,
+		},
+	}
+	for i, tc := range testCases {
+		got, isProject, err := setupCfgRoots(strings.NewReader(tc.content))
+		if err != nil {
+			t.Errorf("test %d: %v", i, err)
+			continue
+		}
+		if diff := cmp.Diff(got, tc.want); diff != "" {
+			t.Errorf("test %d: (-got, +want):%s", i, diff)
+		}
+		if isProject != tc.wantIsProject {
+			t.Errorf("test %d: got isProject %t, want %t", i, isProject, tc.wantIsProject)
+		}
+	}
+}
+
+func TestDetectRoots(t *testing.T) {
+	testCases := []struct {
+		files map[string]string
+		want  []string
+	}{
+		{
+			files: map[string]string{"foo/__init__.py": ""},
+		},
+		{
+			files: map[string]string{"setup.py": "", "foo/__init__.py": ""},
+			want:  []string{"proj"},
+		},
+		{
+			files: map[string]string{"pyproject.toml": "[project]\nname = \"foo\"\n", "src/foo/__init__.py": ""},
+			want:  []string{"proj/src"},
+		},
+		{
+			files: map[string]string{
+				"pyproject.toml":      "[tool.black]\nline-length = 100\n",
+				"setup.cfg":           "[flake8]\nmax-line-length = 100\n",
+				"src/foo/__init__.py": "",
+			},
+		},
+		{
+			files: map[string]string{
+				"pyproject.toml":      "[tool.setuptools]\npackage-dir = {\"\" = \"lib\"}\n",
+				"setup.cfg":           "[options]\npackage_dir =\n    =other\n",
+				"lib/foo/__init__.py": "",
+			},
+			want: []string{"proj/lib"},
+		},
+		{
+			files: map[string]string{
+				"setup.cfg":           "[options.packages.find]\nwhere = lib\n",
+				"lib/foo/__init__.py": "",
+			},
+			want: []string{"proj/lib"},
+		},
+	}
+	for i, tc := range testCases {
+		repoRoot := t.TempDir()
+		for name, content := range tc.files {
+			p := filepath.Join(repoRoot, "proj", filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := detectRoots(repoRoot, "proj")
+		if err != nil {
+			t.Errorf("test %d: %v", i, err)
+			continue
+		}
+		if diff := cmp.Diff(got, tc.want); diff != "" {
+			t.Errorf("test %d: (-got, +want):%s", i, diff)
+		}
+	}
+}
+
+func TestDetectRootsError(t *testing.T) {
+	repoRoot := t.TempDir()
+	if err := os.WriteFile(filepath.Join(repoRoot, "pyproject.toml"), []byte("[tool\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := detectRoots(repoRoot, ""); err == nil {
+		t.Error("expected error for malformed pyproject.toml")
+	}
+}
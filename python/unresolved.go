@@ -0,0 +1,220 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package python
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Maximum number of suggestions for an unresolved import.
+const maxSuggestions = 3
+
+// unresolvedImport is an import for which no Bazel rule could be found.
+type unresolvedImport struct {
+	File        string   `json:"file"`   // Path of the importing file, relative to the repository root.
+	Line        int      `json:"line"`   // Line of the import in the file.
+	Import      string   `json:"import"` // Absolute import specifier.
+	Target      string   `json:"target"` // Label of the rule for the importing file.
+	Suggestions []string `json:"suggestions"`
+	// Whether the module is imported dynamically, e.g. by
+	// importlib.import_module.
+	Dynamic bool `json:"dynamic"`
+	// Whether the import is in a directory with strict mode enabled. Dynamic
+	// imports are not strict, as they may be guarded at run time.
+	strict bool
+}
+
+// unresolvedImports collects the imports which could not be resolved, to be
+// reported once all generated rules have been resolved. It is shared by all
+// copies of the resolver.
+type unresolvedImports struct {
+	imports []unresolvedImport
+}
+
+// Reports the unresolved imports. Exits if any of them are in strict mode.
+func (u *unresolvedImports) report(reportPath string) {
+	if reportPath != "" {
+		if err := writeUnresolvedReportPath(reportPath, u.imports); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var strict int
+	for _, imp := range u.imports {
+		if imp.strict {
+			strict++
+		}
+	}
+	if strict > 0 {
+		log.Fatalf("%d unresolved Python imports in strict mode; no BUILD files were written", strict)
+	}
+}
+
+// Returns the known import specifiers, of generated, external and internal
+// modules, which are closest to the import by edit distance, closest first.
+func suggestImports(imp string, generated map[string]*Module, external map[string]ExternalModule, internal ...map[string]struct{}) []string {
+	type suggestion struct {
+		name     string
+		distance int
+	}
+	maxDistance := len(imp) / 3
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+	var suggestions []suggestion
+	consider := func(name string) {
+		// The distance is at least the difference in length.
+		if len(name) > len(imp)+maxDistance || len(imp) > len(name)+maxDistance {
+			return
+		}
+		d := editDistance(imp, name)
+		if d > maxDistance {
+			return
+		}
+		for _, s := range suggestions {
+			if s.name == name {
+				return
+			}
+		}
+		suggestions = append(suggestions, suggestion{name, d})
+	}
+	for name := range generated {
+		consider(name)
+	}
+	for name := range external {
+		consider(name)
+	}
+	for _, names := range internal {
+		for name := range names {
+			consider(name)
+		}
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if a, b := suggestions[i].distance, suggestions[j].distance; a != b {
+			return a < b
+		}
+		return suggestions[i].name < suggestions[j].name
+	})
+	res := []string{}
+	for i := 0; i < len(suggestions) && i < maxSuggestions; i++ {
+		res = append(res, suggestions[i].name)
+	}
+	return res
+}
+
+// Returns the Levenshtein distance between the strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j-1] + cost
+			if d := prev[j] + 1; d < curr[j] {
+				curr[j] = d
+			}
+			if d := curr[j-1] + 1; d < curr[j] {
+				curr[j] = d
+			}
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// Checks that the report can be written in the format given by the file
+// extension.
+func validateUnresolvedReportPath(path string) error {
+	if ext := filepath.Ext(path); ext != ".json" && ext != ".tsv" {
+		return fmt.Errorf("unresolved imports report %q must have extension .json or .tsv", path)
+	}
+	return nil
+}
+
+// Writes the report of unresolved imports as JSON or TSV, as given by the file
+// extension.
+func writeUnresolvedReportPath(path string, imports []unresolvedImport) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating unresolved imports report: %w", err)
+	}
+	writerFn := writeUnresolvedReportTSV
+	if filepath.Ext(path) == ".json" {
+		writerFn = writeUnresolvedReportJSON
+	}
+	if err := writerFn(f, imports); err != nil {
+		f.Close()
+		return fmt.Errorf("writing unresolved imports report %q: %w", path, err)
+	}
+	return f.Close()
+}
+
+// Sorts the unresolved imports by file and line.
+func sortUnresolvedImports(imports []unresolvedImport) {
+	sort.SliceStable(imports, func(i, j int) bool {
+		if a, b := imports[i].File, imports[j].File; a != b {
+			return a < b
+		}
+		if a, b := imports[i].Line, imports[j].Line; a != b {
+			return a < b
+		}
+		return imports[i].Import < imports[j].Import
+	})
+}
+
+func writeUnresolvedReportJSON(w io.Writer, imports []unresolvedImport) error {
+	sortUnresolvedImports(imports)
+	if imports == nil {
+		imports = []unresolvedImport{}
+	}
+	content, err := json.MarshalIndent(imports, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(content, '\n'))
+	return err
+}
+
+func writeUnresolvedReportTSV(w io.Writer, imports []unresolvedImport) error {
+	sortUnresolvedImports(imports)
+	if _, err := io.WriteString(w, "# file\tline\timport\ttarget\tsuggestions\tdynamic\n"); err != nil {
+		return err
+	}
+	csvW := csv.NewWriter(w)
+	csvW.Comma = '\t'
+	for _, imp := range imports {
+		record := []string{imp.File, strconv.Itoa(imp.Line), imp.Import, imp.Target, strings.Join(imp.Suggestions, ","), strconv.FormatBool(imp.Dynamic)}
+		if err := csvW.Write(record); err != nil {
+			return err
+		}
+	}
+	csvW.Flush()
+	return csvW.Error()
+}
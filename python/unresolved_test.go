@@ -0,0 +1,180 @@
+// Copyright 2023 The Bazel Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License.  You may obtain a copy
+// of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package python
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSuggestImports(t *testing.T) {
+	generated := map[string]*Module{"foo.bar": nil, "foo.baz": nil, "foo": nil}
+	external := map[string]ExternalModule{"requests": {}, "yaml": {}, "foo": {}}
+	internal := map[string]struct{}{"os": {}, "sys": {}}
+	testCases := []struct {
+		imp  string
+		want []string
+	}{
+		{
+			imp:  "reqests",
+			want: []string{"requests"},
+		},
+		{
+			imp:  "foo.bax",
+			want: []string{"foo.bar", "foo.baz"},
+		},
+		{
+			imp:  "sy",
+			want: []string{"sys"},
+		},
+		{
+			// Generated and external; suggested once.
+			imp:  "fo",
+			want: []string{"foo"},
+		},
+		{
+			imp:  "does_not_exist",
+			want: []string{},
+		},
+	}
+	for i, tc := range testCases {
+		got := suggestImports(tc.imp, generated, external, internal)
+		if diff := cmp.Diff(got, tc.want); diff != "" {
+			t.Errorf("test %d: (-got, +want):%s", i, diff)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	testCases := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"abc", "abc", 0},
+		{"kitten", "sitting", 3},
+		{"reqests", "requests", 1},
+	}
+	for i, tc := range testCases {
+		if got := editDistance(tc.a, tc.b); got != tc.want {
+			t.Errorf("test %d: got %d, want %d", i, got, tc.want)
+		}
+		if got := editDistance(tc.b, tc.a); got != tc.want {
+			t.Errorf("test %d (reversed): got %d, want %d", i, got, tc.want)
+		}
+	}
+}
+
+func TestWriteUnresolvedReport(t *testing.T) {
+	imports := []unresolvedImport{
+		{File: "b/mod.py", Line: 3, Import: "yml", Target: "//b:mod", Suggestions: []string{"yaml"}},
+		{File: "a.py", Line: 10, Import: "foo.bax", Target: "//:a", Suggestions: []string{"foo.bar", "foo.baz"}},
+		{File: "a.py", Line: 2, Import: "missing", Target: "//:a", Suggestions: []string{}, Dynamic: true},
+	}
+	wantJSON := `[
+  {
+    "file": "a.py",
+    "line": 2,
+    "import": "missing",
+    "target": "//:a",
+    "suggestions": [],
+    "dynamic": true
+  },
+  {
+    "file": "a.py",
+    "line": 10,
+    "import": "foo.bax",
+    "target": "//:a",
+    "suggestions": [
+      "foo.bar",
+      "foo.baz"
+    ],
+    "dynamic": false
+  },
+  {
+    "file": "b/mod.py",
+    "line": 3,
+    "import": "yml",
+    "target": "//b:mod",
+    "suggestions": [
+      "yaml"
+    ],
+    "dynamic": false
+  }
+]
+`
+	wantTSV := "# file\tline\timport\ttarget\tsuggestions\tdynamic\n" +
+		"a.py\t2\tmissing\t//:a\t\ttrue\n" +
+		"a.py\t10\tfoo.bax\t//:a\tfoo.bar,foo.baz\tfalse\n" +
+		"b/mod.py\t3\tyml\t//b:mod\tyaml\tfalse\n"
+
+	var b strings.Builder
+	if err := writeUnresolvedReportJSON(&b, imports); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(b.String(), wantJSON); diff != "" {
+		t.Errorf("JSON: (-got, +want):%s", diff)
+	}
+	b.Reset()
+	if err := writeUnresolvedReportTSV(&b, imports); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(b.String(), wantTSV); diff != "" {
+		t.Errorf("TSV: (-got, +want):%s", diff)
+	}
+	b.Reset()
+	if err := writeUnresolvedReportJSON(&b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(b.String(), "[]\n"); diff != "" {
+		t.Errorf("empty JSON: (-got, +want):%s", diff)
+	}
+}
+
+// A stale report is replaced by an empty one when checking the flags, as the
+// report is otherwise only written once the generated rules are resolved, and
+// there may be none.
+func TestUnresolvedReportWithoutRules(t *testing.T) {
+	repoRoot := t.TempDir()
+	reportPath := filepath.Join(repoRoot, "report.json")
+	if err := os.WriteFile(reportPath, []byte(`[{"import": "stale"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := config.New()
+	c.RepoRoot = repoRoot
+	var pc Configurer
+	fs := flag.NewFlagSet("gazelle", flag.ContinueOnError)
+	pc.RegisterFlags(fs, "update", c)
+	if err := fs.Parse([]string{"-py-unresolved-report=report.json"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pc.CheckFlags(fs, c); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(got), "[]\n"); diff != "" {
+		t.Errorf("(-got, +want):%s", diff)
+	}
+}
@@ -0,0 +1,13 @@
+import json
+
+import requests  # gazelle:ignore
+import yaml  # noqa: F401  # gazelle:dep //third_party:yaml_shim
+
+# Loaded from a plugin directory at run time.
+# gazelle:dep :csv_plugin
+import plugin
+
+from handwritten import (  # gazelle:ignore
+    helper,
+)
+import handwritten
@@ -0,0 +1,2 @@
+# gazelle:py_kind py_macro
+import json
@@ -0,0 +1,2 @@
+# gazelle:py_ignore_file
+import requests
@@ -0,0 +1,4 @@
+# gazelle:py_kind py_binary
+import app
+
+app.plugin.run()
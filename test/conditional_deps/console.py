@@ -0,0 +1,8 @@
+import sys
+
+import requests
+
+if sys.platform == "win32":
+    import colorama
+    import requests
+    import win32api
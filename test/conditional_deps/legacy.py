@@ -0,0 +1,4 @@
+import sys
+
+if not sys.version_info >= (3, 11):
+    import tomli
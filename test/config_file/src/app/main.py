@@ -0,0 +1,3 @@
+import os
+import yaml
+import requests
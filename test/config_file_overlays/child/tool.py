@@ -0,0 +1,4 @@
+import os
+
+import requests
+import yaml
@@ -0,0 +1,4 @@
+from pkg import b
+
+if __name__ == "__main__":
+    b.run()
@@ -0,0 +1,2 @@
+class d:
+    e = 1
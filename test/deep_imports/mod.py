@@ -0,0 +1,6 @@
+from xml.etree import ElementTree
+
+import google.cloud.storage.blob
+import yaml.constructor.SafeConstructor
+from a.b.c.d import e
+from a.b.c import missing
@@ -0,0 +1 @@
+from alpha import core
@@ -0,0 +1,2 @@
+import delta
+from gamma import util
@@ -0,0 +1 @@
+import beta.api
@@ -0,0 +1 @@
+def load(path): pass
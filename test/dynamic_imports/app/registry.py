@@ -0,0 +1,16 @@
+import importlib
+import pkgutil
+from importlib import import_module
+
+PLUGINS = {
+    "csv": import_module("app.plugins.csv_plugin"),
+    "json": importlib.import_module(".plugins.json_plugin", __package__),
+}
+
+
+def find_plugin(name):
+    return pkgutil.resolve_name("app.plugins.yaml_plugin:load")
+
+
+def optional_plugin():
+    return __import__("not_installed_plugin")
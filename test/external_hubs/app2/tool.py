@@ -0,0 +1,3 @@
+import yaml
+
+from shared import strings
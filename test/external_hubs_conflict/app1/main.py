@@ -0,0 +1,5 @@
+import requests
+import yaml
+
+from app1.lib import util
+from shared import strings
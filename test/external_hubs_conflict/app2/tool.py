@@ -0,0 +1,4 @@
+import yaml
+
+from app1 import main
+from shared import strings
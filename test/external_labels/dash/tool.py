@@ -0,0 +1 @@
+import ruamel.yaml
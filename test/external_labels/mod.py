@@ -0,0 +1,3 @@
+import requests
+import ruamel.yaml
+import yaml
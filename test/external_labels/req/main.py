@@ -0,0 +1,3 @@
+import mod
+import requests
+import yaml
@@ -0,0 +1,3 @@
+import yaml
+from tensorflow.keras import Model
+from tensorflow.python.layers.dense import Dense
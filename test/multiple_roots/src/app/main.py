@@ -0,0 +1,3 @@
+import shared
+import util
+import vendored
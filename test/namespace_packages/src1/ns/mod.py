@@ -0,0 +1,3 @@
+from . import pkg1, sub
+import ns
+import ns.missing
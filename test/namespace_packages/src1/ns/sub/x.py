@@ -0,0 +1 @@
+import ns.pkg2
@@ -0,0 +1,3 @@
+import six
+import zope.event
+from zope.interface import Interface
@@ -0,0 +1,4 @@
+try:
+    import cPickle as pickle
+except ModuleNotFoundError:
+    import pickle
@@ -0,0 +1,5 @@
+try:
+    import simplejson
+    import speedups
+except (ImportError, AttributeError):
+    simplejson = speedups = None
@@ -0,0 +1,3 @@
+import requests
+import yaml
+from yaml import composer
@@ -0,0 +1,2 @@
+from . import b
+from .sub import c
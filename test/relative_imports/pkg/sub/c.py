@@ -0,0 +1,3 @@
+from .. import b
+from ..b import x
+from ... import escape
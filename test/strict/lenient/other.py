@@ -0,0 +1 @@
+import also_missing
@@ -0,0 +1,3 @@
+import lib
+
+import does_not_exist
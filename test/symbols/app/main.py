@@ -0,0 +1,5 @@
+from pkg import Thing, sub
+from pkg.sub import sub_helper, missing
+
+if __name__ == "__main__":
+    sub_helper()
@@ -0,0 +1,7 @@
+__all__ = ["Thing", "helper"]
+
+Thing = object()
+
+
+def helper():
+    pass
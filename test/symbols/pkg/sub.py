@@ -0,0 +1,2 @@
+def sub_helper():
+    pass
@@ -0,0 +1,2 @@
+from pkg import Thing
+from pkg.sub import sub_helper
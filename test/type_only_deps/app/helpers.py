@@ -0,0 +1,8 @@
+import typing
+
+if typing.TYPE_CHECKING:
+    from app.models import fetch
+
+
+class Helper:
+    url = ""
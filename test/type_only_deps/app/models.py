@@ -0,0 +1,15 @@
+from __future__ import annotations
+
+from typing import TYPE_CHECKING
+
+import requests
+
+if TYPE_CHECKING:
+    import requests
+    from numpy.typing import NDArray
+
+    from .helpers import Helper
+
+
+def fetch(helper: Helper) -> NDArray:
+    return requests.get(helper.url)